@@ -3,11 +3,14 @@ package router
 import (
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"github.com/golobby/router/pkg/problem"
 	"io/ioutil"
 	"net/http"
 )
 
 // Context holds the HTTP request, the HTTP responseWriter, the Route, and the Route parameters.
+// The router reuses contexts, so they must not be used after the handlers return.
 type Context interface {
 	// Route returns the dispatched Route
 	Route() *Route
@@ -15,9 +18,17 @@ type Context interface {
 	// Request returns the HTTP request.
 	Request() *http.Request
 
+	// SetRequest replaces the HTTP request.
+	// Server-level middlewares could use it to rewrite the request before routing.
+	SetRequest(request *http.Request)
+
 	// Response return the HTTP responseWriter.
 	Response() http.ResponseWriter
 
+	// Writer returns the ResponseWriter that Response returns.
+	// It tracks the status code, the size, and the committed state of the response.
+	Writer() *ResponseWriter
+
 	// Parameters returns Route parameters.
 	Parameters() map[string]string
 
@@ -58,19 +69,50 @@ type Context interface {
 	// PrettyXML creates and sends an HTTP XML (with indents) response.
 	PrettyXML(status int, body interface{}) error
 
+	// Problem creates and sends an HTTP problem details (RFC 7807) response with the problem status.
+	Problem(p *problem.Problem) error
+
 	// File creates and sends an HTTP response that contains a file.
 	File(status int, contentType, path string) error
 }
 
 // DefaultContext is the default implementation of Context interface.
+// It holds the Route parameter names and values in the order of appearance in the Route path,
+// and creates the parameters map only if it is requested.
 type DefaultContext struct {
 	route      *Route
 	repository *repository
 	request    *http.Request
-	rw         http.ResponseWriter
+	rw         ResponseWriter
+	names      []string
+	values     []string
+	escaped    []string
 	parameters map[string]string
 }
 
+// defaultContext returns the DefaultContext of the given context, which server-level middlewares could wrap.
+// It finds the DefaultContext through its ResponseWriter, unless the wrapper replaces the ResponseWriter too.
+func defaultContext(c Context) (*DefaultContext, error) {
+	if d, ok := c.(*DefaultContext); ok {
+		return d, nil
+	}
+	if w, ok := c.Response().(*ResponseWriter); ok && w.context != nil {
+		return w.context, nil
+	}
+	return nil, errors.New("router: the context of the request is replaced by a middleware")
+}
+
+// reset prepares the context for a new request.
+func (d *DefaultContext) reset(rw http.ResponseWriter, request *http.Request) {
+	d.route = nil
+	d.request = request
+	d.rw.reset(rw)
+	d.names = nil
+	d.values = d.values[:0]
+	d.escaped = d.escaped[:0]
+	d.parameters = nil
+}
+
 // Route returns the dispatched Route
 func (d *DefaultContext) Route() *Route {
 	return d.route
@@ -81,37 +123,85 @@ func (d *DefaultContext) Request() *http.Request {
 	return d.request
 }
 
+// SetRequest replaces the HTTP request.
+// Server-level middlewares could use it to rewrite the request (like its URL path) before routing.
+func (d *DefaultContext) SetRequest(request *http.Request) {
+	d.request = request
+}
+
 // Response return the HTTP responseWriter.
+// It is the ResponseWriter of the context, see Writer.
 func (d *DefaultContext) Response() http.ResponseWriter {
-	return d.rw
+	return &d.rw
+}
+
+// Writer returns the ResponseWriter that Response returns.
+// It tracks the status code, the size, and the committed state of the response.
+func (d *DefaultContext) Writer() *ResponseWriter {
+	return &d.rw
 }
 
 // Parameters returns Route parameters.
+// It leaves out the internal wildcard of mounted handlers, which captures the path without the mount prefix.
 func (d *DefaultContext) Parameters() map[string]string {
+	if d.parameters == nil {
+		d.parameters = make(map[string]string, len(d.names))
+		for i, name := range d.names {
+			if name != "" && name != mountWildcard {
+				d.parameters[name] = d.values[i]
+			}
+		}
+	}
 	return d.parameters
 }
 
 // Parameter returns a router parameter by name.
 func (d *DefaultContext) Parameter(name string) string {
-	if value, exist := d.parameters[name]; exist {
-		return value
+	if i := d.parameter(name); i != -1 {
+		return d.values[i]
 	}
 	return ""
 }
 
 // HasParameter checks if router parameter exists.
 func (d *DefaultContext) HasParameter(name string) bool {
-	_, exist := d.parameters[name]
-	return exist
+	return d.parameter(name) != -1
+}
+
+// escapedContext is a Context that holds the escaped form of the parameter values.
+type escapedContext interface {
+	escapedParameter(name string) (string, bool)
+}
+
+// escapedParameter returns a router parameter by name in its escaped form.
+// It returns false if the router doesn't match the escaped paths, or the parameter doesn't exist.
+func (d *DefaultContext) escapedParameter(name string) (string, bool) {
+	if i := d.parameter(name); i != -1 && len(d.escaped) == len(d.values) {
+		return d.escaped[i], true
+	}
+	return "", false
+}
+
+// parameter finds the position of a router parameter by name.
+// The last one wins if there are multiple parameters with the same name.
+// It returns -1 if the parameter doesn't exist.
+func (d *DefaultContext) parameter(name string) int {
+	if name != "" {
+		for i := len(d.names) - 1; i >= 0; i-- {
+			if d.names[i] == name {
+				return i
+			}
+		}
+	}
+	return -1
 }
 
 // URL generates a URL for given route name and actual parameters.
+// It also finds the routes of the routers mounted in this one, and the routers this one is mounted in.
 // It returns an empty string if it cannot find any route.
 func (d *DefaultContext) URL(route string, parameters map[string]string) string {
-	if route := d.repository.findByName(route); route != nil {
-		return route.URL(parameters)
-	}
-	return ""
+	uri, _ := d.repository.url(route, parameters)
+	return uri
 }
 
 // Bytes creates and sends a custom HTTP response.
@@ -185,6 +275,22 @@ func (d *DefaultContext) PrettyXML(status int, body interface{}) error {
 	return d.Bytes(status, bytes)
 }
 
+// Problem creates and sends an HTTP problem details (RFC 7807) response with the problem status.
+// The status is 500 if the problem has none.
+func (d *DefaultContext) Problem(p *problem.Problem) error {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	d.Response().Header().Set("Content-Type", problem.ContentType)
+	bytes, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return d.Bytes(status, bytes)
+}
+
 // File creates and sends an HTTP response that contains a file.
 func (d *DefaultContext) File(status int, contentType, path string) error {
 	content, err := ioutil.ReadFile(path)
@@ -1,67 +1,467 @@
 package router
 
 import (
+	"github.com/golobby/router/pkg/problem"
 	"github.com/golobby/router/pkg/response"
 	"log"
 	"net/http"
 	"net/url"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // director is the base HTTP handler.
 // It receives the request, and the responseWriter objects then pass them to the Route through the middlewares.
+// It reuses the contexts, so they must not be used after handling the requests.
 type director struct {
-	repository      *repository
-	notFoundHandler Handler
+	repository              *repository
+	chain                   []Handler
+	notFoundHandler         Handler
+	methodNotAllowedHandler Handler
+	errorHandler            ErrorHandler
+	panicReporter           PanicReporter
+	autoHead                bool
+	autoOptions             bool
+	trailingSlash           PathPolicy
+	duplicateSlashes        PathPolicy
+	dotSegments             PathPolicy
+	escapedPath             bool
+	problems                bool
+	contexts                sync.Pool
 }
 
 // ServeHTTP serves HTTP requests and uses other modules to handle them.
+// It passes the requests to the server-level middlewares (if any), and then dispatches them.
 func (d *director) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
-	c := &DefaultContext{
-		repository: d.repository,
-		request:    request,
-		rw:         rw,
+	c := d.contexts.Get().(*DefaultContext)
+	c.reset(rw, request)
+	defer d.contexts.Put(c)
+	defer d.recover(c)
+
+	if err := d.next(0, c); err != nil {
+		d.serveError(c, err)
+	}
+}
+
+// use adds the server-level middlewares as the next link of the chain. The first middleware is the outermost one.
+// It wraps the middlewares around a handler that calls the next link (or the dispatcher), so each middleware wraps
+// only once, even if the router gets more middlewares later.
+func (d *director) use(middlewares []Middleware) {
+	link := len(d.chain)
+	handler := Handler(func(c Context) error {
+		return d.next(link+1, c)
+	})
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
 	}
+	d.chain = append(d.chain, handler)
+}
+
+// next runs the given link of the chain of server-level middlewares, or the dispatcher after the last one.
+func (d *director) next(link int, c Context) error {
+	if link < len(d.chain) {
+		return d.chain[link](c)
+	}
+	return d.dispatch(c)
+}
 
-	uri, err := url.ParseRequestURI(request.RequestURI)
+// dispatch finds the Route of the context request and runs it, or runs the 404, 405, and OPTIONS handlers.
+// It routes the current request of the context, so the server-level middlewares could rewrite it.
+// It handles the errors of the routes with the application error handler, so it returns nil.
+func (d *director) dispatch(context Context) error {
+	c, err := defaultContext(context)
+	if err != nil {
+		return err
+	}
+	request := c.request
+
+	path, err := requestPath(request, d.escapedPath)
 	if err != nil {
 		d.serveNotFoundError(c)
-		return
+		return nil
+	}
+
+	prefix := ""
+	if d.repository.versioning.Prefix {
+		prefix, path = splitVersion(path)
+	}
+
+	path, ok := d.canonicalize(c, prefix, path)
+	if !ok {
+		return nil
+	}
+
+	host := hostname(request.Host)
+
+	head := false
+	l, values := d.repository.findByRequest(request.Method, host, path, c.values)
+	if l == nil && request.Method == http.MethodHead && d.autoHead {
+		if l, values = d.repository.findByRequest(http.MethodGet, host, path, c.values); l != nil && l.autoHead() {
+			c.rw.discard = true
+			head = true
+		} else {
+			l = nil
+		}
 	}
 
-	route, parameters := d.repository.findByRequest(request.Method, uri.Path)
+	if l == nil {
+		if routes := d.repository.findByURI(host, path); len(routes) > 0 {
+			methods, options := d.allowedMethods(routes)
+			if request.Method == http.MethodOptions && options {
+				d.serveOptions(c, methods)
+			} else {
+				d.serveMethodNotAllowedError(c, methods)
+			}
+		} else {
+			d.serveNotFoundError(c)
+		}
+		return nil
+	}
+
+	requested := d.requestedVersion(request, prefix)
+	route, status := selectRoute(l, request, head, requested)
 	if route == nil {
-		d.serveNotFoundError(c)
-		return
+		d.serveConstraintError(c, status)
+		return nil
+	}
+
+	// The deprecation headers belong to the requested version, as routes could fall back to older versions.
+	announced := route.version
+	if requested != nil {
+		announced = d.repository.registeredVersion(requested)
+	}
+	if announced != nil {
+		announced.headers(c.Response().Header())
+	}
+
+	if d.escapedPath {
+		c.escaped = append(c.escaped[:0], values...)
+		unescape(values)
 	}
 
 	c.route = route
-	c.parameters = parameters
+	c.names = l.parameters(route)
+	c.values = values
+	// The server-level middlewares could have requested the parameters before routing.
+	c.parameters = nil
 
 	if err = route.stack[len(route.stack)-1](c); err != nil {
-		d.serveInternalError(c, err)
+		d.serveError(c, err)
 	}
+	return nil
 }
 
-// serveInternalError handles internal errors.
-func (d *director) serveInternalError(c Context, err error) {
-	log.Println("router: uncaught error=" + err.Error())
-	_ = c.JSON(http.StatusInternalServerError, response.M{"message": "Internal error."})
+// requestPath extracts the path from the request URL, unescaped or in its escaped form.
+// So it respects the handlers that rewrite the URL before the router, like http.StripPrefix.
+// It parses the request URI only if the request has no URL, and avoids it when it has no escaped characters.
+func requestPath(request *http.Request, escaped bool) (string, error) {
+	if u := request.URL; u != nil {
+		path := u.Path
+		if escaped {
+			path = u.EscapedPath()
+		}
+		if path == "" {
+			path = "/"
+		}
+		return path, nil
+	}
+
+	uri := request.RequestURI
+	if strings.HasPrefix(uri, "/") && strings.IndexByte(uri, '%') == -1 {
+		if i := strings.IndexByte(uri, '?'); i != -1 {
+			return uri[:i], nil
+		}
+		return uri, nil
+	}
+
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	if escaped {
+		return u.EscapedPath(), nil
+	}
+
+	return u.Path, nil
+}
+
+// unescape decodes the parameter values that are extracted from the escaped path in place.
+func unescape(values []string) {
+	for i, value := range values {
+		if strings.IndexByte(value, '%') == -1 {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			values[i] = unescaped
+		}
+	}
+}
+
+// canonicalize applies the path policies to the request URI path and returns the path to dispatch.
+// It collapses duplicate slashes, resolves dot segments, and adds or removes the trailing slash when only the
+// other form leads to a Route. It returns false if it has redirected the request to the canonical path instead.
+// The version prefix (if any) is removed from the path already, and the redirect location keeps it.
+func (d *director) canonicalize(c *DefaultContext, prefix, path string) (string, bool) {
+	canonical, redirect := path, false
+
+	if d.duplicateSlashes != PolicyStrict {
+		if p := collapseSlashes(canonical); p != canonical {
+			canonical, redirect = p, redirect || d.duplicateSlashes == PolicyRedirect
+		}
+	}
+
+	if d.dotSegments != PolicyStrict {
+		if p := resolveDots(canonical); p != canonical {
+			canonical, redirect = p, redirect || d.dotSegments == PolicyRedirect
+		}
+	}
+
+	if d.trailingSlash != PolicyStrict && !d.exists(c, canonical) {
+		if p := toggleTrailingSlash(canonical); p != canonical && d.exists(c, p) {
+			canonical, redirect = p, redirect || d.trailingSlash == PolicyRedirect
+		}
+	}
+
+	// A location starting with two slashes would point to another host.
+	if redirect && !strings.HasPrefix(prefix+canonical, "//") {
+		d.serveRedirect(c, prefix+canonical)
+		return "", false
+	}
+
+	return canonical, true
+}
+
+// exists checks if the given path leads to any Route, whatever the request method is.
+func (d *director) exists(c *DefaultContext, path string) bool {
+	host := hostname(c.request.Host)
+	if l, _ := d.repository.findByRequest(c.request.Method, host, path, c.values); l != nil {
+		return true
+	}
+
+	return len(d.repository.findByURI(host, path)) > 0
+}
+
+// requestedVersion resolves the API version of the request from the version prefix of the path, or the request
+// headers. It returns nil if the request has no (valid) version.
+func (d *director) requestedVersion(request *http.Request, prefix string) *version {
+	name := strings.TrimPrefix(prefix, "/")
+	if name == "" {
+		name = d.repository.versioning.resolveVersion(request)
+	}
+	if name == "" {
+		return nil
+	}
+
+	v, err := parseVersion(name)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// selectRoute picks the Route of the leaf with the latest version (not after the requested one, if any) whose
+// constraints match the request. The first one wins among the routes with the same version.
+// For HEAD requests served by GET routes, it skips the routes that don't serve them.
+// If no Route matches, it returns the status of the furthest stage that the routes have failed at.
+func selectRoute(l *leaf, request *http.Request, head bool, requested *version) (*Route, int) {
+	var selected *Route
+	stage := 0
+
+	for _, route := range l.routes {
+		if head && !route.autoHead {
+			continue
+		}
+		if requested != nil && compareVersions(route.version, requested) > 0 {
+			continue
+		}
+		if selected != nil && compareVersions(route.version, selected.version) <= 0 {
+			continue
+		}
+
+		status := route.match(request)
+		if status == 0 {
+			selected = route
+			continue
+		}
+
+		for i, s := range stages {
+			if s == status && i > stage {
+				stage = i
+			}
+		}
+	}
+
+	if selected != nil {
+		return selected, 0
+	}
+	return nil, stages[stage]
+}
+
+// allowedMethods lists the methods of the given routes, plus the automatically handled HEAD and OPTIONS methods.
+// It also reports whether the router should answer OPTIONS requests for these routes automatically.
+func (d *director) allowedMethods(routes []*Route) ([]string, bool) {
+	methods := make([]string, 0, len(routes)+2)
+	exists := map[string]bool{}
+	head, options := false, d.autoOptions
+
+	for _, route := range routes {
+		methods = append(methods, route.method)
+		exists[route.method] = true
+
+		if route.method == http.MethodGet && route.autoHead && d.autoHead {
+			head = true
+		}
+		if !route.autoOptions {
+			options = false
+		}
+	}
+
+	if head && !exists[http.MethodHead] {
+		methods = append(methods, http.MethodHead)
+	}
+	if options && !exists[http.MethodOptions] {
+		methods = append(methods, http.MethodOptions)
+	}
+
+	sort.Strings(methods)
+
+	return methods, options
+}
+
+// recover recovers the panics of handlers and handles them as PanicError errors with the application error handler.
+// It reports the panics to the panic reporter (if any), and skips the error handler if the response is committed.
+// It doesn't recover http.ErrAbortHandler panics, as they're meant to abort the response.
+func (d *director) recover(c *DefaultContext) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	if value == http.ErrAbortHandler {
+		panic(value)
+	}
+
+	err := &PanicError{Value: value, Stack: debug.Stack()}
+	if d.panicReporter != nil {
+		d.panicReporter(c, err)
+	}
+
+	if c.rw.Written() {
+		log.Println("router: panic after response is written=" + err.Error())
+		return
+	}
+
+	d.serveError(c, err)
+}
+
+// serveError handles the errors of handlers with the application error handler.
+func (d *director) serveError(c Context, err error) {
+	d.errorHandler(c, err)
 }
 
 // serveNotFoundError handles 404 errors.
 func (d *director) serveNotFoundError(c Context) {
 	err := d.notFoundHandler(c)
 	if err != nil {
-		d.serveInternalError(c, err)
+		d.serveError(c, err)
+	}
+}
+
+// serveMethodNotAllowedError handles 405 errors.
+// It sets the Allow header to the given methods before calling the handler.
+func (d *director) serveMethodNotAllowedError(c Context, methods []string) {
+	c.Response().Header().Set("Allow", strings.Join(methods, ", "))
+	err := d.methodNotAllowedHandler(c)
+	if err != nil {
+		d.serveError(c, err)
+	}
+}
+
+// serveConstraintError handles requests that match the path of some routes, but none of their constraints.
+// It runs the 404 handler or the error handler with an HTTPError of the given status (415 or 406).
+func (d *director) serveConstraintError(c Context, status int) {
+	switch status {
+	case http.StatusUnsupportedMediaType:
+		d.serveError(c, NewHTTPError(status, "Unsupported media type."))
+	case http.StatusNotAcceptable:
+		d.serveError(c, NewHTTPError(status, "Not acceptable."))
+	default:
+		d.serveNotFoundError(c)
+	}
+}
+
+// serveOptions handles OPTIONS requests for the routes without an explicit OPTIONS Route.
+// It responds with an empty body and the Allow header set to the given methods.
+func (d *director) serveOptions(c Context, methods []string) {
+	c.Response().Header().Set("Allow", strings.Join(methods, ", "))
+	if err := c.Empty(http.StatusNoContent); err != nil {
+		d.serveError(c, err)
+	}
+}
+
+// serveRedirect redirects the request to the given path and keeps its query string.
+// It responds with 301 to GET and HEAD requests, and 308 to the others, so clients preserve the method and body.
+// The path is in the escaped form if the escaped path option is enabled, so it doesn't escape it again.
+func (d *director) serveRedirect(c Context, path string) {
+	location := &url.URL{Path: path}
+	if d.escapedPath {
+		if unescaped, err := url.PathUnescape(path); err == nil {
+			location.Path, location.RawPath = unescaped, path
+		}
+	}
+	if u := c.Request().URL; u != nil {
+		location.RawQuery = u.RawQuery
+	} else if i := strings.IndexByte(c.Request().RequestURI, '?'); i != -1 {
+		location.RawQuery = c.Request().RequestURI[i+1:]
+	}
+
+	status := http.StatusPermanentRedirect
+	if c.Request().Method == http.MethodGet || c.Request().Method == http.MethodHead {
+		status = http.StatusMovedPermanently
+	}
+
+	c.Response().Header().Set("Location", location.String())
+	if err := c.Empty(status); err != nil {
+		d.serveError(c, err)
 	}
 }
 
 // newDirector creates a new director instance.
 func newDirector(repository *repository) *director {
-	return &director{
+	d := &director{
 		repository: repository,
-		notFoundHandler: func(c Context) error {
-			return c.JSON(http.StatusNotFound, response.M{"message": "Not found."})
+		contexts: sync.Pool{
+			New: func() interface{} {
+				c := &DefaultContext{repository: repository}
+				c.rw.context = c
+				return c
+			},
 		},
+		autoHead:    true,
+		autoOptions: true,
+	}
+
+	d.notFoundHandler = func(c Context) error {
+		if d.problems {
+			return c.Problem(problem.New(http.StatusNotFound))
+		}
+		return c.JSON(http.StatusNotFound, response.M{"message": "Not found."})
 	}
+	d.methodNotAllowedHandler = func(c Context) error {
+		if d.problems {
+			return c.Problem(problem.New(http.StatusMethodNotAllowed))
+		}
+		return c.JSON(http.StatusMethodNotAllowed, response.M{"message": "Method not allowed."})
+	}
+	d.errorHandler = func(c Context, err error) {
+		if d.problems {
+			ProblemErrorHandler(c, err)
+		} else {
+			DefaultErrorHandler(c, err)
+		}
+	}
+
+	return d
 }
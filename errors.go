@@ -0,0 +1,150 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golobby/router/pkg/problem"
+	"github.com/golobby/router/pkg/response"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// HTTPError is an error with an HTTP status code and a message for the clients.
+// Handlers could return it to respond with the status code instead of 500 (Internal error).
+// The internal error (the cause) is logged but not exposed to the clients, while the details (like validation
+// errors) are exposed.
+type HTTPError struct {
+	Status   int
+	Message  string
+	Internal error
+	Details  interface{}
+}
+
+// Error returns the status code, the message, and the internal error (if any).
+func (e *HTTPError) Error() string {
+	s := strconv.Itoa(e.Status) + " " + e.Message
+	if e.Internal != nil {
+		s += ": " + e.Internal.Error()
+	}
+	return s
+}
+
+// Unwrap returns the internal error, so errors.Is and errors.As could find it.
+func (e *HTTPError) Unwrap() error {
+	return e.Internal
+}
+
+// WithInternal returns a copy of the error with the given internal error (cause).
+func (e *HTTPError) WithInternal(err error) *HTTPError {
+	c := *e
+	c.Internal = err
+	return &c
+}
+
+// WithDetails returns a copy of the error with the given details.
+func (e *HTTPError) WithDetails(details interface{}) *HTTPError {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// NewHTTPError creates a new HTTPError instance.
+// The message is the standard text of the status code if it's empty.
+func NewHTTPError(status int, message string) *HTTPError {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return &HTTPError{Status: status, Message: message}
+}
+
+// PanicError is the error of handlers that panic.
+// It holds the panic value and the stack trace of the panicking goroutine.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error returns the panic value.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it's an error, so errors.Is and errors.As could find it.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// PanicReporter is an interface for the application panic reporter.
+// The router calls it when handlers panic, before handling the panics with the application error handler.
+type PanicReporter func(c Context, err *PanicError)
+
+// ErrorHandler is an interface for the application error handler.
+// The router calls it when handlers (including the 404 and 405 handlers) return errors.
+type ErrorHandler func(c Context, err error)
+
+// DefaultErrorHandler is the default application error handler.
+// It responds to HTTPError errors with their status codes, messages and details, to problem.Problem errors with
+// their problem details, and to other errors with 500 (Internal error). It logs the errors that lead to 5xx responses.
+func DefaultErrorHandler(c Context, err error) {
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		serveProblem(c, p, err)
+		return
+	}
+
+	var e *HTTPError
+	if !errors.As(err, &e) {
+		log.Println("router: uncaught error=" + err.Error())
+		_ = c.JSON(http.StatusInternalServerError, response.M{"message": "Internal error."})
+		return
+	}
+
+	if e.Status >= http.StatusInternalServerError {
+		log.Println("router: uncaught error=" + e.Error())
+	}
+
+	body := response.M{"message": e.Message}
+	if e.Details != nil {
+		body["details"] = e.Details
+	}
+	_ = c.JSON(e.Status, body)
+}
+
+// ProblemErrorHandler is the application error handler that responds with problem details (RFC 7807).
+// It converts HTTPError errors to problems with their status codes, custom messages (as the details), and details (as
+// the `details` extension), and other errors to 500 (Internal Server Error) problems.
+// It logs the errors that lead to 5xx responses.
+func ProblemErrorHandler(c Context, err error) {
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		serveProblem(c, p, err)
+		return
+	}
+
+	var e *HTTPError
+	if !errors.As(err, &e) {
+		serveProblem(c, problem.New(http.StatusInternalServerError), err)
+		return
+	}
+
+	p = problem.New(e.Status)
+	if e.Message != p.Title {
+		p.Detail = e.Message
+	}
+	if e.Details != nil {
+		p.Extensions = map[string]interface{}{"details": e.Details}
+	}
+	serveProblem(c, p, err)
+}
+
+// serveProblem responds with the problem, and logs the error if it leads to a 5xx response.
+func serveProblem(c Context, p *problem.Problem, err error) {
+	if p.Status == 0 || p.Status >= http.StatusInternalServerError {
+		log.Println("router: uncaught error=" + err.Error())
+	}
+	_ = c.Problem(p)
+}
@@ -0,0 +1,132 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+)
+
+// mountWildcard is the name of the wildcard that captures the path of the requests to mounted handlers.
+const mountWildcard = "__MOUNTED__"
+
+// Group is a group of routes with common attributes (prefix, middlewares, host, and API version).
+// It holds its own attributes instead of the current group of the router, so it could be passed to other modules,
+// and different groups could register their routes concurrently.
+type Group struct {
+	repository *repository
+	state      *state
+}
+
+// Files defines a new static file server on the given path (URI) for the given directory root, like Router.Files.
+func (g *Group) Files(path, directory string) *Route {
+	path, wildcard := filesPath(path)
+	return g.GET(path, filesHandler(wildcard, directory))
+}
+
+// Mount attaches the handler to the given prefix in the group, like Router.Mount.
+func (g *Group) Mount(prefix string, handler http.Handler) {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	var mount *Route
+	if prefix != "" {
+		mount = g.Any(prefix, mountHandler(mountWildcard, handler))
+	}
+	g.Any(prefix+"/*"+mountWildcard, mountHandler(mountWildcard, handler))
+
+	switch router := handler.(type) {
+	case *Router:
+		g.repository.addChild(router.repository, mount)
+	case Router:
+		g.repository.addChild(router.repository, mount)
+	}
+}
+
+// Map defines a new Route in the group by HTTP method and path and assigns a handler.
+// It panics if the path is invalid or conflicts with the existing routes, see MapE.
+func (g *Group) Map(method, path string, handler Handler) *Route {
+	route, err := g.MapE(method, path, handler)
+	if err != nil {
+		panic(err)
+	}
+	return route
+}
+
+// MapE defines a new Route in the group by HTTP method and path and assigns a handler, like Map.
+// It returns an error if the path is invalid or conflicts with the existing routes.
+func (g *Group) MapE(method, path string, handler Handler) (*Route, error) {
+	return g.repository.add(g.state, method, path, handler)
+}
+
+// Use adds the middlewares to the group for the next routes.
+func (g *Group) Use(middlewares ...Middleware) {
+	g.state = g.state.extend("", middlewares)
+}
+
+// Group creates a subgroup with the given prefix and middlewares added to the attributes of the group.
+func (g *Group) Group(prefix string, middlewares ...Middleware) *Group {
+	return newGroup(g.repository, g.state.extend(prefix, middlewares))
+}
+
+// Host creates a subgroup that only matches requests to the hosts matching the given pattern, like Router.Host.
+// It panics if the pattern is invalid.
+func (g *Group) Host(pattern string) *Group {
+	h, err := g.repository.findHost(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return newGroup(g.repository, g.state.withHost(h))
+}
+
+// Version creates a subgroup with the given API version, like Router.Version.
+// It panics if the version is invalid.
+func (g *Group) Version(version string) *Group {
+	v, err := g.repository.findVersion(version)
+	if err != nil {
+		panic(err)
+	}
+	return newGroup(g.repository, g.state.withVersion(v))
+}
+
+// Any maps a method-agnostic Route in the group.
+func (g *Group) Any(path string, handler Handler) *Route {
+	return g.Map(":__METHOD__", path, handler)
+}
+
+// GET maps a GET Route in the group.
+func (g *Group) GET(path string, handler Handler) *Route {
+	return g.Map("GET", path, handler)
+}
+
+// POST maps a POST Route in the group.
+func (g *Group) POST(path string, handler Handler) *Route {
+	return g.Map("POST", path, handler)
+}
+
+// PUT maps a PUT Route in the group.
+func (g *Group) PUT(path string, handler Handler) *Route {
+	return g.Map("PUT", path, handler)
+}
+
+// PATCH maps a PATCH Route in the group.
+func (g *Group) PATCH(path string, handler Handler) *Route {
+	return g.Map("PATCH", path, handler)
+}
+
+// DELETE maps a DELETE Route in the group.
+func (g *Group) DELETE(path string, handler Handler) *Route {
+	return g.Map("DELETE", path, handler)
+}
+
+// HEAD maps a HEAD Route in the group.
+func (g *Group) HEAD(path string, handler Handler) *Route {
+	return g.Map("HEAD", path, handler)
+}
+
+// OPTIONS maps a OPTIONS Route in the group.
+func (g *Group) OPTIONS(path string, handler Handler) *Route {
+	return g.Map("OPTIONS", path, handler)
+}
+
+// newGroup creates a new Group instance.
+func newGroup(repository *repository, state *state) *Group {
+	return &Group{repository, state}
+}
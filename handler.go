@@ -2,6 +2,7 @@ package router
 
 import (
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -10,12 +11,55 @@ import (
 type Handler func(c Context) error
 
 // filesHandler creates a special handler for serving static files.
-// It returns files stored in the given root directory that matches the stripped request URI (path).
-func filesHandler(path, directory string) Handler {
+// It returns files stored in the given root directory that matches the path captured by the given wildcard.
+func filesHandler(wildcard, directory string) Handler {
 	return func(c Context) error {
-		fs := http.FileServer(http.Dir(directory))
-		h := http.StripPrefix(strings.TrimRight(path, "*"), fs)
-		h.ServeHTTP(c.Response(), c.Request())
+		request := stripRequest(c.Request(), "/"+c.Parameter(wildcard), "")
+		http.FileServer(http.Dir(directory)).ServeHTTP(c.Response(), request)
 		return nil
 	}
 }
+
+// mountHandler creates a special handler for mounted HTTP handlers.
+// It passes the request to the handler with the path captured by the given wildcard (without the mount prefix).
+// The path keeps its escaped form too, if the router matches the escaped paths.
+func mountHandler(wildcard string, handler http.Handler) Handler {
+	return func(c Context) error {
+		rawPath := ""
+		if e, ok := c.(escapedContext); ok {
+			if value, ok := e.escapedParameter(wildcard); ok {
+				rawPath = "/" + value
+			}
+		}
+
+		request := stripRequest(c.Request(), "/"+c.Parameter(wildcard), rawPath)
+		handler.ServeHTTP(c.Response(), request)
+		return nil
+	}
+}
+
+// stripRequest creates a shallow copy of the request with the given path (without the prefix of the Route),
+// and its escaped form (if it's known, otherwise empty).
+// It updates the request URI too, so the handler sees the same path in both.
+func stripRequest(original *http.Request, path, rawPath string) *http.Request {
+	request := new(http.Request)
+	*request = *original
+	request.URL = new(url.URL)
+	*request.URL = *original.URL
+	request.URL.Path = path
+	request.URL.RawPath = rawPath
+	request.RequestURI = request.URL.RequestURI()
+	return request
+}
+
+// filesPath names the unnamed wildcard at the end of the given path `filepath`.
+// It returns the path and the wildcard name.
+func filesPath(path string) (string, string) {
+	if strings.HasSuffix(path, "*") {
+		path += "filepath"
+	}
+
+	wildcard, _ := parseWildcard(path[strings.LastIndex(path, "/")+1:])
+
+	return path, wildcard
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// host holds a host pattern, like `{tenant}.example.com`, and the radix tree of its routes.
+// A host parameter matches a whole or a part of a label (the text between dots), unless it has an inline pattern.
+// Host names are case-insensitive, so the pattern matches them regardless of their case.
+type host struct {
+	pattern string
+	tokens  []token
+	regexp  *regexp.Regexp
+	groups  []int
+	tree    *tree
+}
+
+// match checks if the host pattern matches the given hostname (without port).
+// It appends the parameter values to the given slice and returns them.
+func (h *host) match(hostname string, values []string) ([]string, bool) {
+	matches := h.regexp.FindStringSubmatch(hostname)
+	if matches == nil {
+		return values, false
+	}
+
+	for _, group := range h.groups {
+		values = append(values, matches[group])
+	}
+
+	return values, true
+}
+
+// url generates the host from the pattern with the given parameters.
+// It keeps the parameters that are not given as they are.
+func (h *host) url(parameters map[string]string) string {
+	var b strings.Builder
+	for _, tk := range h.tokens {
+		if value, exist := parameters[tk.parameter]; tk.parameter != "" && exist {
+			b.WriteString(value)
+		} else {
+			b.WriteString(tk.text)
+		}
+	}
+
+	return b.String()
+}
+
+// hostname extracts the hostname from the request host, which may include a port.
+func hostname(requestHost string) string {
+	if strings.IndexByte(requestHost, ':') == -1 {
+		return requestHost
+	}
+	if name, _, err := net.SplitHostPort(requestHost); err == nil {
+		return name
+	}
+
+	return requestHost
+}
+
+// newHost creates a new host instance.
+// The radix tree of the host shares the given parameter patterns, and puts the host parameter names before the
+// path parameter names of its routes.
+// It returns an error if the pattern has invalid parameters.
+func newHost(pattern string, patterns map[string]*regexp.Regexp) (*host, error) {
+	tokens, err := parseSegment(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, tk := range tokens {
+		if tk.parameter == "" {
+			b.WriteString(regexp.QuoteMeta(tk.text))
+			continue
+		}
+
+		expression := `[^.]+`
+		if tk.expression != "" {
+			expression = tk.expression
+			if e, exist := types[expression]; exist {
+				expression = e
+			}
+		}
+
+		b.WriteString(fmt.Sprintf("(?P<p%d>(?:%s))", len(names), expression))
+		names = append(names, tk.parameter)
+	}
+	b.WriteString("$")
+
+	compiled, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("router: invalid host pattern %q: %v", pattern, err)
+	}
+
+	groups := make([]int, len(names))
+	for i := range names {
+		groups[i] = compiled.SubexpIndex(fmt.Sprintf("p%d", i))
+	}
+
+	t := newTree()
+	t.patterns = patterns
+	t.names = names
+
+	return &host{pattern: pattern, tokens: tokens, regexp: compiled, groups: groups, tree: t}, nil
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// lifecycle holds the HTTP servers that serve the router, and the signals that shut them down gracefully.
+// It remembers the shutdown, so the servers that start afterward stop immediately.
+type lifecycle struct {
+	mutex   sync.Mutex
+	servers []*http.Server
+	signals []os.Signal
+	timeout time.Duration
+	down    bool
+}
+
+// serve runs the server with the given function (like ListenAndServe) until it stops.
+// It shuts the server down gracefully on the signals (if there is any) and waits for the in-flight requests.
+// It returns nil if the server stops because of a shutdown, or immediately if the router is shut down already.
+func (l *lifecycle) serve(server *http.Server, run func() error) error {
+	l.mutex.Lock()
+	if l.down {
+		l.mutex.Unlock()
+		return nil
+	}
+	l.servers = append(l.servers, server)
+	signals, timeout := l.signals, l.timeout
+	l.mutex.Unlock()
+
+	defer l.remove(server)
+
+	if len(signals) == 0 {
+		return closed(run())
+	}
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+
+	stopped := make(chan struct{})
+	shutdown := make(chan error, 1)
+	go func() {
+		defer close(shutdown)
+		select {
+		case <-received:
+			ctx := context.Background()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			shutdown <- server.Shutdown(ctx)
+		case <-stopped:
+		}
+	}()
+
+	err := run()
+	close(stopped)
+
+	// The server stops immediately on shutdowns, so it waits for the shutdown caused by the signal (if any).
+	if e, ok := <-shutdown; ok && errors.Is(err, http.ErrServerClosed) {
+		return e
+	}
+
+	return closed(err)
+}
+
+// closed converts the error of stopped servers to nil if they're closed because of a shutdown.
+func closed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// remove forgets the server, as it has stopped.
+func (l *lifecycle) remove(server *http.Server) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i, s := range l.servers {
+		if s == server {
+			l.servers = append(l.servers[:i], l.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+// shutdown shuts the running servers down gracefully, and returns the first error.
+// It marks the lifecycle as shut down, so the servers that are about to start don't keep running.
+func (l *lifecycle) shutdown(ctx context.Context) error {
+	l.mutex.Lock()
+	l.down = true
+	servers := append([]*http.Server(nil), l.servers...)
+	l.mutex.Unlock()
+
+	var first error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// matcher holds a Route constraint on request attributes other than the method, host, and path.
+// The status is the response status code when the constraint fails for all the routes of a request URI.
+type matcher struct {
+	status int
+	match  func(request *http.Request) bool
+}
+
+// stages holds the statuses of the matchers in the order of evaluation.
+// Request attributes (headers, query, scheme) come first, then the content type, and finally the accepted types.
+var stages = [...]int{http.StatusNotFound, http.StatusUnsupportedMediaType, http.StatusNotAcceptable}
+
+// headerMatcher creates a matcher that checks if the request header has the given value.
+func headerMatcher(key, value string) matcher {
+	return matcher{http.StatusNotFound, func(request *http.Request) bool {
+		return request.Header.Get(key) == value
+	}}
+}
+
+// queryMatcher creates a matcher that checks if the request query string parameter has the given value.
+func queryMatcher(key, value string) matcher {
+	return matcher{http.StatusNotFound, func(request *http.Request) bool {
+		return request.URL != nil && request.URL.Query().Get(key) == value
+	}}
+}
+
+// schemeMatcher creates a matcher that checks if the request URL scheme is the given one.
+func schemeMatcher(scheme string) matcher {
+	return matcher{http.StatusNotFound, func(request *http.Request) bool {
+		return strings.EqualFold(requestScheme(request), scheme)
+	}}
+}
+
+// consumesMatcher creates a matcher that checks if the request content type is one of the given media types.
+func consumesMatcher(mediaTypes []string) matcher {
+	return matcher{http.StatusUnsupportedMediaType, func(request *http.Request) bool {
+		contentType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+		if err != nil {
+			return false
+		}
+
+		for _, mediaType := range mediaTypes {
+			if matchMediaType(mediaType, contentType) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// producesMatcher creates a matcher that checks if the request accepts any of the given media types.
+// A request without the Accept header accepts all media types.
+func producesMatcher(mediaTypes []string) matcher {
+	return matcher{http.StatusNotAcceptable, func(request *http.Request) bool {
+		accept := request.Header.Get("Accept")
+		if accept == "" {
+			return true
+		}
+
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+
+			for _, mediaType := range mediaTypes {
+				if matchMediaType(mediaRange, mediaType) {
+					return true
+				}
+			}
+		}
+		return false
+	}}
+}
+
+// matchMediaType checks if the media type is in the media range, like `*/*`, `text/*`, or `text/html`.
+func matchMediaType(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || strings.EqualFold(mediaRange, mediaType) {
+		return true
+	}
+
+	if strings.HasSuffix(mediaRange, "/*") {
+		return len(mediaType) > len(mediaRange)-1 && strings.EqualFold(mediaType[:len(mediaRange)-1], mediaRange[:len(mediaRange)-1])
+	}
+
+	return false
+}
+
+// requestScheme returns the scheme of the request URL, which is https for TLS connections and http otherwise,
+// unless the request URI is absolute.
+func requestScheme(request *http.Request) string {
+	if request.URL != nil && request.URL.Scheme != "" {
+		return request.URL.Scheme
+	}
+	if request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,163 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// types holds the built-in parameter types and their patterns.
+// Route paths can use them instead of regular expressions, like `/users/:id<int>` or `/users/{id:int}`.
+var types = map[string]string{
+	"int":   `[0-9]+`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	"alpha": `[a-zA-Z]+`,
+	"slug":  `[a-z0-9]+(?:-[a-z0-9]+)*`,
+	"date":  `[0-9]{4}-(?:0[1-9]|1[0-2])-(?:0[1-9]|[12][0-9]|3[01])`,
+}
+
+// token holds a piece of a route path part, which is either a static text or a parameter.
+// The text of a parameter token is its original form in the route path.
+type token struct {
+	text       string
+	parameter  string
+	expression string
+	pattern    *regexp.Regexp
+}
+
+// parseSegment splits a route path part into static texts and parameters.
+// It supports `:name`, `:name<pattern>`, `{name}`, and `{name:pattern}` parameter forms.
+// The name of the `:name` forms consists of letters, digits, and underscores, so the static text could follow it.
+// The pattern could be a regular expression or a built-in type.
+// It returns an error if the part has unclosed brackets or adjacent parameters.
+func parseSegment(part string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(part); {
+		var t token
+
+		switch {
+		case part[i] == ':' && i+1 < len(part) && isNameByte(part[i+1]):
+			j := i + 1
+			for j < len(part) && isNameByte(part[j]) {
+				j++
+			}
+			t.parameter = part[i+1 : j]
+
+			if j < len(part) && part[j] == '<' {
+				end := closing(part, j, '<', '>')
+				if end == -1 {
+					return nil, fmt.Errorf("router: unclosed parameter pattern in %q", part)
+				}
+				t.expression = part[j+1 : end]
+				j = end + 1
+			}
+
+			t.text = part[i:j]
+		case part[i] == '{':
+			end := closing(part, i, '{', '}')
+			if end == -1 {
+				return nil, fmt.Errorf("router: unclosed parameter in %q", part)
+			}
+
+			t.parameter = part[i+1 : end]
+			if k := strings.Index(t.parameter, ":"); k != -1 {
+				t.parameter, t.expression = t.parameter[:k], t.parameter[k+1:]
+			}
+
+			t.text = part[i : end+1]
+		default:
+			j := i + 1
+			for j < len(part) && part[j] != ':' && part[j] != '{' {
+				j++
+			}
+			t.text = part[i:j]
+		}
+
+		if t.parameter == "" && len(tokens) > 0 && tokens[len(tokens)-1].parameter == "" {
+			tokens[len(tokens)-1].text += t.text
+		} else if t.parameter != "" && len(tokens) > 0 && tokens[len(tokens)-1].parameter != "" {
+			return nil, fmt.Errorf("router: parameters must be separated by static text in %q", part)
+		} else {
+			tokens = append(tokens, t)
+		}
+
+		i += len(t.text)
+	}
+
+	return tokens, nil
+}
+
+// parseParameter parses a route path part and returns the parameter name and its inline pattern.
+// It returns false if the part is not a single parameter.
+func parseParameter(part string) (name, pattern string, ok bool) {
+	tokens, err := parseSegment(part)
+	if err != nil || len(tokens) != 1 || tokens[0].parameter == "" {
+		return "", "", false
+	}
+
+	return tokens[0].parameter, tokens[0].expression, true
+}
+
+// parseOptional parses a route path part and reports whether it is an optional parameter.
+// It supports the parameter forms followed by a question mark, like `:name?` or `{name:pattern}?`.
+// It returns the part without the question mark.
+func parseOptional(part string) (string, bool) {
+	if strings.HasSuffix(part, "?") {
+		if _, _, ok := parseParameter(part[:len(part)-1]); ok {
+			return part[:len(part)-1], true
+		}
+	}
+
+	return part, false
+}
+
+// parseWildcard parses a route path part and returns the wildcard name.
+// It supports `*` and `*name` forms, the latter captures the rest of the request URI as a parameter.
+// It returns false if the part is not a wildcard.
+func parseWildcard(part string) (name string, ok bool) {
+	if strings.HasPrefix(part, "*") {
+		return part[1:], true
+	}
+
+	return "", false
+}
+
+// compilePattern compiles the pattern of a parameter, so it matches whole values only.
+// The pattern could be a regular expression or a built-in type.
+func compilePattern(name, pattern string) (*regexp.Regexp, error) {
+	if expression, exist := types[pattern]; exist {
+		pattern = expression
+	}
+
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("router: invalid pattern %q for parameter %q: %v", pattern, name, err)
+	}
+
+	return compiled, nil
+}
+
+// isNameByte checks if the given byte could be a part of a `:name` parameter name.
+func isNameByte(b byte) bool {
+	return b == '_' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
+
+// closing finds the index of the bracket closing the one at the given index.
+// It returns -1 if the bracket is not closed.
+func closing(s string, start int, open, close byte) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,113 @@
+package router
+
+import "strings"
+
+// PathPolicy determines how the router treats request URIs that are not in their canonical form.
+type PathPolicy int
+
+const (
+	// PolicyStrict matches the request URI as it is, so a non-canonical form may not lead to any Route.
+	PolicyStrict PathPolicy = iota
+	// PolicyRedirect redirects the request to the canonical form of its URI.
+	// It responds with 301 (Moved Permanently) to GET and HEAD requests, and with 308 (Permanent Redirect) to
+	// the others, so clients preserve the method and body.
+	PolicyRedirect
+	// PolicyEqual dispatches the request as if its URI were in the canonical form.
+	PolicyEqual
+)
+
+// collapseSlashes replaces the sequences of slashes in the path with single slashes.
+func collapseSlashes(path string) string {
+	if !strings.Contains(path, "//") {
+		return path
+	}
+
+	var b strings.Builder
+	b.Grow(len(path))
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(path[i])
+	}
+
+	return b.String()
+}
+
+// resolveDots removes the "." and ".." segments from the path (RFC 3986, section 5.2.4).
+// A path that ends with a dot segment keeps its trailing slash, and ".." segments never go above the root.
+func resolveDots(path string) string {
+	if !strings.Contains(path, "/.") {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	resolved := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			if len(resolved) > 1 {
+				resolved = resolved[:len(resolved)-1]
+			}
+		default:
+			resolved = append(resolved, segment)
+			continue
+		}
+		if last {
+			resolved = append(resolved, "")
+		}
+	}
+
+	return strings.Join(resolved, "/")
+}
+
+// toggleTrailingSlash removes the trailing slash of the path, or appends one if it has none.
+// The root path has no alternative, so it remains intact.
+func toggleTrailingSlash(path string) string {
+	if path == "/" {
+		return path
+	}
+	if strings.HasSuffix(path, "/") {
+		return path[:len(path)-1]
+	}
+
+	return path + "/"
+}
+
+// equalFold reports whether the strings are equal under ASCII case-folding.
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] && lowerByte(a[i]) != lowerByte(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lowerByte returns the lower case of the ASCII letter, or the byte itself if it's not an upper case letter.
+func lowerByte(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+
+	return b
+}
+
+// swapByteCase returns the other case of the ASCII letter, or the byte itself if it's not a letter.
+func swapByteCase(b byte) byte {
+	switch {
+	case 'A' <= b && b <= 'Z':
+		return b + 'a' - 'A'
+	case 'a' <= b && b <= 'z':
+		return b - 'a' + 'A'
+	}
+
+	return b
+}
@@ -0,0 +1,102 @@
+// Package problem provides the problem details for HTTP APIs (RFC 7807).
+// The problem details documents are JSON objects with the `application/problem+json` media type.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of the problem details documents.
+const ContentType = "application/problem+json"
+
+// Problem holds the details of an HTTP API problem.
+// The extensions are additional members of the document, and they appear next to the standard members.
+// It's an error too, so handlers could return it.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+// Error returns the title and the detail of the problem.
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+// MarshalJSON creates the problem details document.
+// It omits the empty standard members, and flattens the extensions (the standard members win the same names).
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+
+	for name, value := range map[string]string{
+		"type": p.Type, "title": p.Title, "detail": p.Detail, "instance": p.Instance,
+	} {
+		if value != "" {
+			members[name] = value
+		} else {
+			delete(members, name)
+		}
+	}
+
+	if p.Status != 0 {
+		members["status"] = p.Status
+	} else {
+		delete(members, "status")
+	}
+
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON parses the problem details document.
+// It keeps the members other than the standard ones in the extensions.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	*p = Problem{}
+	for name, value := range members {
+		var err error
+		switch name {
+		case "type":
+			err = json.Unmarshal(value, &p.Type)
+		case "title":
+			err = json.Unmarshal(value, &p.Title)
+		case "status":
+			err = json.Unmarshal(value, &p.Status)
+		case "detail":
+			err = json.Unmarshal(value, &p.Detail)
+		case "instance":
+			err = json.Unmarshal(value, &p.Instance)
+		default:
+			var extension interface{}
+			if err = json.Unmarshal(value, &extension); err == nil {
+				if p.Extensions == nil {
+					p.Extensions = map[string]interface{}{}
+				}
+				p.Extensions[name] = extension
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// New creates a new Problem instance with the given status and its standard text as the title.
+func New(status int) *Problem {
+	return &Problem{Title: http.StatusText(status), Status: status}
+}
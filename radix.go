@@ -1,158 +1,543 @@
 package router
 
 import (
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 )
 
-// node holds a radix tree node including content (route path part), node children, and related route.
+// Node kinds. Children of each kind are searched in this order.
+const (
+	staticKind = iota
+	parameterKind
+	wildcardKind
+)
+
+// leaf holds the routes of a path and the names of their parameters in the order of appearance in the path.
+// Parameter values are collected in the same order while searching, so the names are precomputed at insert time.
+// Multiple routes share a leaf when they have request constraints or versions, and they are kept in the order of
+// definition. Each route has its own names, as the routes of different versions could name the parameters differently.
+type leaf struct {
+	routes []*Route
+	names  [][]string
+}
+
+// parameters returns the parameter names of the given Route of the leaf.
+func (l *leaf) parameters(route *Route) []string {
+	for i, r := range l.routes {
+		if r == route {
+			return l.names[i]
+		}
+	}
+	return nil
+}
+
+// autoHead checks if any Route of the leaf serves HEAD requests automatically.
+func (l *leaf) autoHead() bool {
+	for _, route := range l.routes {
+		if route.autoHead {
+			return true
+		}
+	}
+	return false
+}
+
+// node holds a radix tree node.
+// Static nodes hold a compressed piece of paths as their content, and their children are indexed by first byte.
+// Parameter and wildcard nodes hold their original form in the route path as their content,
+// and the parameter name (and the inline pattern) they capture.
 type node struct {
-	content  string
-	Route    *Route
-	Children []*node
+	kind       int
+	content    string
+	parameter  string
+	expression string
+	pattern    *regexp.Regexp
+	indices    string
+	statics    []*node
+	params     []*node
+	wildcard   *node
+	leaf       *leaf
 }
 
-// newNode creates a new node instance.
-func newNode(route *Route, token string) *node {
-	return &node{content: token, Route: route}
+// piece holds a piece of a route path, which is a static text, a parameter, or a wildcard.
+type piece struct {
+	kind int
+	token
 }
 
-// tree holds radix tree head node and route parameter patterns.
+// tree holds radix tree root nodes (per HTTP method) and compiled route parameter patterns.
+// Method-agnostic routes are stored in a separate root.
+// When it's case-insensitive, static texts match the URIs regardless of the case of their ASCII letters.
+// The names hold the parameters that are captured before the path (like host parameters) for all routes.
 type tree struct {
-	patterns map[string]string
-	head     *node
+	patterns    map[string]*regexp.Regexp
+	roots       map[string]*node
+	any         *node
+	names       []string
+	insensitive bool
+}
+
+// addPattern compiles the given pattern and assigns it to the route parameter.
+// The pattern must match the whole parameter value.
+func (t *tree) addPattern(name, pattern string) error {
+	compiled, err := compilePattern(name, pattern)
+	if err != nil {
+		return err
+	}
+
+	t.patterns[name] = compiled
+
+	return nil
 }
 
 // add appends a new route and inserts required nodes in the radix tree.
-func (t *tree) add(route *Route) {
-	parts := strings.Split(route.method+route.path, "/")
-	t.insert(t.head, newNode(route, parts[len(parts)-1]), parts, 0)
+// A route with optional parameters is inserted once per each possible number of present parameters.
+// It returns an error if the route path has an invalid parameter or wildcard, an optional parameter
+// followed by a required part, or a conflict with the existing routes. The tree remains intact on errors.
+func (t *tree) add(route *Route) error {
+	parts := strings.Split(route.path, "/")
+
+	first := -1
+	for i, part := range parts {
+		if stripped, optional := parseOptional(part); optional {
+			parts[i] = stripped
+			if first == -1 {
+				first = i
+			}
+		} else if first != -1 {
+			return fmt.Errorf("router: optional parameters must be at the end of the path %q", route.path)
+		}
+	}
+
+	variants := [][]string{parts}
+	if first != -1 {
+		variants = variants[:0]
+		for n := first; n <= len(parts); n++ {
+			if n == 1 {
+				variants = append(variants, []string{"", ""})
+			} else {
+				variants = append(variants, parts[:n])
+			}
+		}
+	}
+
+	root := t.root(route.method)
+
+	pieces := make([][]piece, len(variants))
+	for i, variant := range variants {
+		var err error
+		if pieces[i], err = t.pieces(variant); err != nil {
+			return err
+		}
+		if err = t.check(root, route, pieces[i]); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pieces {
+		t.insert(root, route, p)
+	}
+
+	return nil
 }
 
-// findByRequest searches for a route by request method and URI.
-// It returns the route and its parameters.
-func (t *tree) findByRequest(method, uri string) (*Route, map[string]string) {
-	parts := strings.Split(method+uri, "/")
-	parameters := map[int]string{}
+// root returns the root node of the given method, and creates it if it doesn't exist.
+func (t *tree) root(method string) *node {
+	if _, _, ok := parseParameter(method); ok {
+		return t.any
+	}
 
-	if node := t.searchByParts(t.head, parts, 0, parameters); node != nil {
-		return node.Route, t.extractParameters(node.Route, parameters)
+	if _, exist := t.roots[method]; !exist {
+		t.roots[method] = &node{}
 	}
 
-	return nil, map[string]string{}
+	return t.roots[method]
 }
 
-// findByName searches for a route by name.
-func (t *tree) findByName(name string) *Route {
-	if node := t.searchByName(t.head, name); node != nil {
-		return node.Route
+// check walks through the existing nodes of the given pieces and looks for conflicts.
+// A route conflicts with an existing one with the same path (parameter names and patterns aside), version, and no
+// request constraints (as the new route would never match), a parameter with the same pattern but a different name at the same position,
+// or a wildcard with a different name at the same position.
+// The parameters and wildcards could have different names in different versions, as the routes share their nodes.
+func (t *tree) check(root *node, route *Route, pieces []piece) error {
+	n := root
+	position := len(t.names)
+
+	for _, p := range pieces {
+		switch p.kind {
+		case staticKind:
+			for text := p.text; text != ""; {
+				i := strings.IndexByte(n.indices, text[0])
+				if i == -1 || !strings.HasPrefix(text, n.statics[i].content) {
+					return nil
+				}
+				n = n.statics[i]
+				text = text[len(n.content):]
+			}
+		case parameterKind:
+			next := (*node)(nil)
+			for _, child := range n.params {
+				if t.source(child.parameter, child.pattern) != t.source(p.parameter, p.pattern) {
+					continue
+				}
+				if existing, name := child.renamed(route.version, position, p.parameter); existing != nil {
+					return fmt.Errorf(
+						"router: parameter %q of route %v is ambiguous with parameter %q of route %v",
+						p.parameter, route, name, existing,
+					)
+				}
+				next = child
+			}
+			if next == nil {
+				return nil
+			}
+			n = next
+			position++
+		case wildcardKind:
+			if n.wildcard == nil {
+				return nil
+			}
+			if existing, name := n.wildcard.renamed(route.version, position, p.parameter); existing != nil {
+				return fmt.Errorf(
+					"router: wildcard %q of route %v conflicts with wildcard %q of route %v",
+					p.text, route, "*"+name, existing,
+				)
+			}
+			n = n.wildcard
+			position++
+		}
+	}
+
+	if n.leaf != nil {
+		for _, existing := range n.leaf.routes {
+			if len(existing.matchers) == 0 && compareVersions(existing.version, route.version) == 0 {
+				return fmt.Errorf("router: route %v conflicts with route %v", route, existing)
+			}
+		}
 	}
 
 	return nil
 }
 
-// extractParameters finds the route parameters (name-value pairs) by mapping the parameter position and route path.
-func (t *tree) extractParameters(route *Route, parameterValues map[int]string) map[string]string {
-	routeParts := strings.Split(route.method+route.path, "/")
+// source returns the source of the pattern of a parameter (inline or defined).
+// It returns an empty string if the parameter has no pattern.
+func (t *tree) source(name string, pattern *regexp.Regexp) string {
+	if pattern == nil {
+		pattern = t.patterns[name]
+	}
+	if pattern == nil {
+		return ""
+	}
+
+	return pattern.String()
+}
+
+// insert adds a new route (with the given path pieces) to the radix tree.
+// It splits the existing static nodes when they share only a prefix of their content with the new path.
+func (t *tree) insert(root *node, route *Route, pieces []piece) {
+	n := root
+	names := append([]string(nil), t.names...)
 
-	parameters := map[string]string{}
-	for i, value := range parameterValues {
-		parameters[routeParts[i][1:]] = value
+	for _, p := range pieces {
+		switch p.kind {
+		case staticKind:
+			n = n.insertStatic(p.text)
+		case parameterKind:
+			n = t.insertParameter(n, p.token)
+			names = append(names, p.parameter)
+		case wildcardKind:
+			if n.wildcard == nil {
+				n.wildcard = &node{kind: wildcardKind, content: p.text, parameter: p.parameter}
+			}
+			n = n.wildcard
+			names = append(names, p.parameter)
+		}
 	}
 
-	return parameters
+	if n.leaf == nil {
+		n.leaf = &leaf{}
+	}
+	n.leaf.routes = append(n.leaf.routes, route)
+	n.leaf.names = append(n.leaf.names, names)
 }
 
-// searchByParts finds the node by parts.
-func (t *tree) searchByParts(parent *node, parts []string, position int, parameters map[int]string) *node {
-	isLeaf := position == len(parts)-1
+// pieces converts the path parts to the pieces (static texts, parameters, and wildcards) of the radix tree.
+// The adjacent static texts (including slashes) join together, and inline parameter patterns are compiled.
+func (t *tree) pieces(parts []string) ([]piece, error) {
+	var pieces []piece
+	static := ""
+
+	for i, part := range parts {
+		if i > 0 {
+			static += "/"
+		}
 
-	for _, child := range parent.Children {
-		delete(parameters, position)
+		if name, ok := parseWildcard(part); ok {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("router: wildcards must be at the end of the path %q", strings.Join(parts, "/"))
+			}
+			if static != "" {
+				pieces = append(pieces, piece{kind: staticKind, token: token{text: static}})
+			}
+			return append(pieces, piece{kind: wildcardKind, token: token{text: part, parameter: name}}), nil
+		}
 
-		isWildcard := child.content == "*"
+		tokens, err := parseSegment(part)
+		if err != nil {
+			return nil, err
+		}
 
-		if ok, name, value := t.match(child.content, parts[position]); ok {
-			if name != "" {
-				parameters[position] = value
+		for _, tk := range tokens {
+			if tk.parameter == "" {
+				static += tk.text
+				continue
 			}
 
-			if isLeaf || isWildcard {
-				return child
+			if tk.expression != "" {
+				if tk.pattern, err = compilePattern(tk.parameter, tk.expression); err != nil {
+					return nil, err
+				}
 			}
 
-			if node := t.searchByParts(child, parts, position+1, parameters); node != nil {
-				return node
+			if static != "" {
+				pieces = append(pieces, piece{kind: staticKind, token: token{text: static}})
+				static = ""
 			}
+			pieces = append(pieces, piece{kind: parameterKind, token: tk})
 		}
 	}
 
-	return nil
+	if static != "" {
+		pieces = append(pieces, piece{kind: staticKind, token: token{text: static}})
+	}
+
+	return pieces, nil
 }
 
-// searchByName finds the node by route name.
-func (t *tree) searchByName(node *node, name string) *node {
-	if node.Route != nil {
-		if node.Route.name == name {
-			return node
+// insertStatic inserts the static text under the node and returns the node at its end.
+func (n *node) insertStatic(text string) *node {
+	for text != "" {
+		i := strings.IndexByte(n.indices, text[0])
+		if i == -1 {
+			child := &node{kind: staticKind, content: text}
+			n.indices += text[:1]
+			n.statics = append(n.statics, child)
+			return child
+		}
+
+		child := n.statics[i]
+
+		common := 0
+		for common < len(child.content) && common < len(text) && child.content[common] == text[common] {
+			common++
+		}
+
+		if common < len(child.content) {
+			split := &node{
+				kind:    staticKind,
+				content: child.content[:common],
+				indices: child.content[common : common+1],
+				statics: []*node{child},
+			}
+			child.content = child.content[common:]
+			n.statics[i] = split
+			child = split
 		}
+
+		n = child
+		text = text[common:]
 	}
 
-	for _, child := range node.Children {
-		if n := t.searchByName(child, name); n != nil {
-			return n
+	return n
+}
+
+// insertParameter inserts the parameter under the node and returns the parameter node.
+// Parameters with the same pattern (inline or defined) share a node, whatever their forms are, so the routes of
+// different versions could name them differently.
+func (t *tree) insertParameter(n *node, tk token) *node {
+	for _, child := range n.params {
+		if t.source(child.parameter, child.pattern) == t.source(tk.parameter, tk.pattern) {
+			return child
 		}
 	}
 
-	return nil
+	child := &node{
+		kind:       parameterKind,
+		content:    tk.text,
+		parameter:  tk.parameter,
+		expression: tk.expression,
+		pattern:    tk.pattern,
+	}
+	n.params = append(n.params, child)
+
+	return child
 }
 
-// insert adds a new route to the radix tree by recursive traversing.
-func (t *tree) insert(parent, node *node, parts []string, position int) {
-	isLeaf := position == len(parts)-1
+// renamed searches the subtree of the node for a route with the given version (or no version, if it's nil) that
+// names its parameter at the given position other than the given name.
+// It returns the route and the name of its parameter, or nil if there is none.
+func (n *node) renamed(v *version, position int, name string) (*Route, string) {
+	if n.leaf != nil {
+		for i, route := range n.leaf.routes {
+			if compareVersions(route.version, v) == 0 && n.leaf.names[i][position] != name {
+				return route, n.leaf.names[i][position]
+			}
+		}
+	}
 
-	for i, child := range parent.Children {
-		if child.content == parts[position] {
-			if isLeaf {
-				node.Children = parent.Children[i].Children
-				parent.Children[i] = node
-			} else {
-				t.insert(child, node, parts, position+1)
+	for _, children := range [][]*node{n.statics, n.params} {
+		for _, child := range children {
+			if route, other := child.renamed(v, position, name); route != nil {
+				return route, other
 			}
-			return
 		}
 	}
 
-	if isLeaf {
-		parent.Children = append(parent.Children, node)
-	} else {
-		newNode := newNode(nil, parts[position])
-		parent.Children = append(parent.Children, newNode)
-		t.insert(newNode, node, parts, position+1)
+	if n.wildcard != nil {
+		return n.wildcard.renamed(v, position, name)
+	}
+	return nil, ""
+}
+
+// tailed checks if any static text follows the parameter node in the same path part.
+func (n *node) tailed() bool {
+	for i := 0; i < len(n.indices); i++ {
+		if n.indices[i] != '/' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findByRequest searches for a route by request method and URI.
+// It appends the parameter values to the given slice and returns them with the found leaf.
+func (t *tree) findByRequest(method, uri string, values []string) (*leaf, []string) {
+	if root, exist := t.roots[method]; exist {
+		if l, values := t.search(root, uri, values); l != nil {
+			return l, values
+		}
 	}
+
+	return t.search(t.any, uri, values)
 }
 
-// match compares a request URI part with a route path part and returns the boolean result.
-// It also returns route parameter name and its real value if exist
-func (t *tree) match(routePart, UriPart string) (bool, string, string) {
-	if strings.HasPrefix(routePart, ":") {
-		name := routePart[1:]
-		if pattern, exist := t.patterns[name]; exist {
-			pathPattern := regexp.MustCompile("^" + pattern + "$")
-			if pathPattern.MatchString(UriPart) {
-				return true, name, UriPart
+// findByURI searches for the routes of all HTTP methods that match the given URI.
+// It ignores method-agnostic routes and returns the routes sorted by their methods.
+func (t *tree) findByURI(uri string) []*Route {
+	var routes []*Route
+
+	for _, root := range t.roots {
+		if l, _ := t.search(root, uri, nil); l != nil {
+			routes = append(routes, l.routes[0])
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].method < routes[j].method
+	})
+
+	return routes
+}
+
+// search finds the leaf for the rest of the URI (after the content of the given node).
+// It tries the static child first, then parameters with patterns (inline or defined), plain parameters,
+// and finally the wildcard. It backtracks when a deeper search fails, so the result is independent of the
+// order of insertion. A parameter value ends at the next slash, or before a static text that follows the
+// parameter in the same path part (the shortest value that leads to a match).
+func (t *tree) search(n *node, uri string, values []string) (*leaf, []string) {
+	if uri == "" && n.leaf != nil {
+		return n.leaf, values
+	}
+
+	if uri != "" {
+		if l, values := t.searchStatic(n, uri[0], uri, values); l != nil {
+			return l, values
+		}
+		if other := swapByteCase(uri[0]); t.insensitive && other != uri[0] {
+			if l, values := t.searchStatic(n, other, uri, values); l != nil {
+				return l, values
 			}
-		} else {
-			return true, name, UriPart
 		}
-	} else if routePart == "*" {
-		return true, "", ""
 	}
 
-	return routePart == UriPart, "", ""
+	if len(n.params) > 0 {
+		end := strings.IndexByte(uri, '/')
+		if end == -1 {
+			end = len(uri)
+		}
+
+		for _, patterned := range [2]bool{true, false} {
+			for _, child := range n.params {
+				pattern := child.pattern
+				if pattern == nil {
+					pattern = t.patterns[child.parameter]
+				}
+				if (pattern != nil) != patterned {
+					continue
+				}
+
+				// Parameter values are never empty.
+				if end == 0 {
+					continue
+				}
+
+				start := end
+				if child.tailed() {
+					start = 1
+				}
+
+				for i := start; i <= end; i++ {
+					if i != end && !t.indexed(child, uri[i]) {
+						continue
+					}
+					if pattern != nil && !pattern.MatchString(uri[:i]) {
+						continue
+					}
+					if l, values := t.search(child, uri[i:], append(values, uri[:i])); l != nil {
+						return l, values
+					}
+				}
+			}
+		}
+	}
+
+	if n.wildcard != nil && n.wildcard.leaf != nil {
+		return n.wildcard.leaf, append(values, uri)
+	}
+
+	return nil, values
+}
+
+// searchStatic searches for the leaf through the static child of the node indexed by the given byte.
+func (t *tree) searchStatic(n *node, index byte, uri string, values []string) (*leaf, []string) {
+	i := strings.IndexByte(n.indices, index)
+	if i == -1 {
+		return nil, values
+	}
+
+	child := n.statics[i]
+	if len(uri) < len(child.content) {
+		return nil, values
+	}
+	if prefix := uri[:len(child.content)]; prefix != child.content && !(t.insensitive && equalFold(prefix, child.content)) {
+		return nil, values
+	}
+
+	return t.search(child, uri[len(child.content):], values)
+}
+
+// indexed checks if the node has a static child that starts with the given byte (in either case, if insensitive).
+func (t *tree) indexed(n *node, b byte) bool {
+	if strings.IndexByte(n.indices, b) != -1 {
+		return true
+	}
+
+	return t.insensitive && strings.IndexByte(n.indices, swapByteCase(b)) != -1
 }
 
 // newTree creates a new radix tree instance.
 func newTree() *tree {
-	return &tree{head: newNode(nil, ""), patterns: map[string]string{}}
+	return &tree{patterns: map[string]*regexp.Regexp{}, roots: map[string]*node{}, any: &node{}}
 }
@@ -1,25 +1,64 @@
 package router
 
-// repository holds the radix tree and current stateStack.
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// repository holds the radix tree, the hosts (with their own radix trees), the API versions and the way to resolve
+// them, the Route names index, and current stateStack.
+// It also holds the repositories of the routers mounted in this one, and the repository this one is mounted in
+// (the parent) with the mount Route (nil for the root prefix), to generate URLs across them.
+// The mutex guards the registration, so groups could register their routes concurrently.
 type repository struct {
-	tree  *tree
-	state *stateStack
+	tree       *tree
+	hosts      []*host
+	versions   map[string]*version
+	versioning Versioning
+	names      map[string]*Route
+	state      *stateStack
+	children   []*repository
+	parent     *repository
+	mount      *Route
+	mutex      sync.Mutex
 }
 
-// addRoute adds a new Route to the repository.
-func (r *repository) addRoute(method, path string, handler Handler) *Route {
-	route := newRoute(method, r.state.prefix()+path, r.stack(handler))
-	r.tree.add(route)
-	return route
+// addRoute adds a new Route to the repository with the attributes of current state (group).
+// It returns an error if the Route path is invalid or conflicts with the existing routes.
+func (r *repository) addRoute(method, path string, handler Handler) (*Route, error) {
+	return r.add(r.state.current(), method, path, handler)
 }
 
-// stack merges handler and middlewares to create a stack of callables the Route is going to call.
-func (r *repository) stack(handler Handler) []Handler {
-	stack := make([]Handler, len(r.state.middlewares())+1)
+// add adds a new Route to the repository with the attributes of the given state (group).
+// The Route goes to the radix tree of the state host, if there is any.
+// It returns an error if the Route path is invalid or conflicts with the existing routes.
+func (r *repository) add(s *state, method, path string, handler Handler) (*Route, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	route := newRoute(r, method, s.prefix+path, r.stack(s, handler))
+	route.host, route.version = s.host, s.version
+
+	t := r.tree
+	if route.host != nil {
+		t = route.host.tree
+	}
+
+	if err := t.add(route); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// stack merges handler and middlewares of the state to create a stack of callables the Route is going to call.
+func (r *repository) stack(s *state, handler Handler) []Handler {
+	stack := make([]Handler, len(s.middlewares)+1)
 	stack = append(stack, handler)
 
-	for i := len(r.state.middlewares()); i > 0; i-- {
-		stack = append(stack, r.state.middlewares()[i-1](stack[len(stack)-1]))
+	for i := len(s.middlewares); i > 0; i-- {
+		stack = append(stack, s.middlewares[i-1](stack[len(stack)-1]))
 	}
 
 	return stack
@@ -27,33 +66,250 @@ func (r *repository) stack(handler Handler) []Handler {
 
 // addGroup adds a new group of routes to the repository.
 func (r *repository) addGroup(prefix string, middleware []Middleware, body func()) {
-	r.state.push(prefix, middleware)
+	r.state.push(r.state.current().extend(prefix, middleware))
+	body()
+	r.state.pop()
+}
+
+// addHost adds a new group of routes with the given host pattern to the repository.
+// It returns an error if the host pattern is invalid.
+func (r *repository) addHost(pattern string, body func()) error {
+	h, err := r.findHost(pattern)
+	if err != nil {
+		return err
+	}
+
+	r.state.push(r.state.current().withHost(h))
+	body()
+	r.state.pop()
+
+	return nil
+}
+
+// addVersion adds a new group of routes with the given API version to the repository.
+// It returns an error if the version is invalid.
+func (r *repository) addVersion(name string, body func()) error {
+	v, err := r.findVersion(name)
+	if err != nil {
+		return err
+	}
+
+	r.state.push(r.state.current().withVersion(v))
 	body()
 	r.state.pop()
+
+	return nil
+}
+
+// deprecateVersion marks the API version as deprecated with the given deprecation and sunset dates (if known).
+// It returns an error if the version is invalid.
+func (r *repository) deprecateVersion(name string, deprecation, sunset time.Time) error {
+	v, err := r.findVersion(name)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v.deprecated, v.deprecation, v.sunset = true, deprecation, sunset
+
+	return nil
+}
+
+// findVersion searches for the API version with the given name, and creates it if it doesn't exist.
+// It returns an error if the version is invalid.
+func (r *repository) findVersion(name string) (*version, error) {
+	v, err := parseVersion(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, exist := r.versions[v.name]; exist {
+		return existing, nil
+	}
+	r.versions[v.name] = v
+
+	return v, nil
+}
+
+// registeredVersion searches for the registered API version that equals the given one, with its deprecation details.
+// It returns nil if the version is not registered.
+func (r *repository) registeredVersion(v *version) *version {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, existing := range r.versions {
+		if compareVersions(existing, v) == 0 {
+			return existing
+		}
+	}
+
+	return nil
+}
+
+// findHost searches for the host with the given pattern, and creates it if it doesn't exist.
+// It returns an error if the host pattern is invalid.
+func (r *repository) findHost(pattern string) (*host, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, h := range r.hosts {
+		if h.pattern == pattern {
+			return h, nil
+		}
+	}
+
+	h, err := newHost(pattern, r.tree.patterns)
+	if err != nil {
+		return nil, err
+	}
+	h.tree.insensitive = r.tree.insensitive
+	r.hosts = append(r.hosts, h)
+
+	return h, nil
+}
+
+// setInsensitive makes the radix trees case-insensitive or case-sensitive.
+func (r *repository) setInsensitive(enabled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tree.insensitive = enabled
+	for _, h := range r.hosts {
+		h.tree.insensitive = enabled
+	}
 }
 
 // updateGroup push the current group without pop.
 func (r *repository) updateGroup(prefix string, middleware []Middleware) {
-	r.state.push(prefix, middleware)
+	r.state.push(r.state.current().extend(prefix, middleware))
 }
 
 // addParameterPattern adds a new Route parameter pattern to the radix tree.
-func (r *repository) addParameterPattern(name, pattern string) {
-	r.tree.patterns[name] = pattern
+// It returns an error if the pattern is not a valid regular expression.
+func (r *repository) addParameterPattern(name, pattern string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.tree.addPattern(name, pattern)
 }
 
-// findByRequest searches for a Route that matches the given HTTP method and URI.
-// It returns the Route and its parameters.
-func (r *repository) findByRequest(method, uri string) (*Route, map[string]string) {
-	return r.tree.findByRequest(method, uri)
+// findByRequest searches for a Route that matches the given HTTP method, hostname, and URI.
+// It searches the hosts matching the hostname in the order of definition, then the routes without hosts.
+// It returns the leaf holding the Route and its parameter names, and the parameter values appended to the given slice.
+func (r *repository) findByRequest(method, hostname, uri string, values []string) (*leaf, []string) {
+	for _, h := range r.hosts {
+		if hv, ok := h.match(hostname, values); ok {
+			if l, hv := h.tree.findByRequest(method, uri, hv); l != nil {
+				return l, hv
+			}
+		}
+	}
+
+	return r.tree.findByRequest(method, uri, values)
+}
+
+// findByURI searches for the routes of all HTTP methods that match the given hostname and URI.
+// It returns the routes of the first host (or the routes without hosts) that has any.
+func (r *repository) findByURI(hostname, uri string) []*Route {
+	for _, h := range r.hosts {
+		if _, ok := h.match(hostname, nil); ok {
+			if routes := h.tree.findByURI(uri); len(routes) > 0 {
+				return routes
+			}
+		}
+	}
+
+	return r.tree.findByURI(uri)
+}
+
+// addName indexes the Route by the given name, sets the Route name, and removes its previous name from the index.
+// It returns an error if another Route already has the name.
+func (r *repository) addName(name string, route *Route) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, exist := r.names[name]; exist && existing != route {
+		return fmt.Errorf("router: name %q of route %v is already taken by route %v", name, route, existing)
+	}
+
+	if r.names[route.name] == route {
+		delete(r.names, route.name)
+	}
+	r.names[name] = route
+	route.name = name
+
+	return nil
 }
 
 // findByName searches for a Route with the give name.
 func (r *repository) findByName(name string) *Route {
-	return r.tree.findByName(name)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.names[name]
+}
+
+// addChild mounts the given repository in this one under the given Route (nil for the root prefix).
+func (r *repository) addChild(child *repository, mount *Route) {
+	r.mutex.Lock()
+	r.children = append(r.children, child)
+	r.mutex.Unlock()
+
+	child.mutex.Lock()
+	child.parent, child.mount = r, mount
+	child.mutex.Unlock()
+}
+
+// url generates the URL of the named Route with the given parameters.
+// It searches this repository and the mounted ones, then the parents (and their mounted repositories).
+// It returns false if it cannot find the Route.
+func (r *repository) url(name string, parameters map[string]string) (string, bool) {
+	for current := r; current != nil; current = current.parent {
+		if uri, ok := current.findURL(name, parameters); ok {
+			for ; current.parent != nil; current = current.parent {
+				uri = current.mounted(uri, parameters)
+			}
+			return uri, true
+		}
+	}
+
+	return "", false
+}
+
+// findURL generates the URL of the named Route in this repository or the mounted ones, relative to this one.
+func (r *repository) findURL(name string, parameters map[string]string) (string, bool) {
+	if route := r.findByName(name); route != nil {
+		return route.URL(parameters), true
+	}
+
+	for _, child := range r.children {
+		if uri, ok := child.findURL(name, parameters); ok {
+			return child.mounted(uri, parameters), true
+		}
+	}
+
+	return "", false
+}
+
+// mounted adds the mount prefix of this repository to the given URL.
+func (r *repository) mounted(uri string, parameters map[string]string) string {
+	if r.mount == nil {
+		return uri
+	}
+	return strings.TrimSuffix(r.mount.URL(parameters), "/") + uri
 }
 
 // newRepository creates a new repository instance.
 func newRepository() *repository {
-	return &repository{newTree(), newStateStack()}
+	return &repository{
+		tree:     newTree(),
+		versions: map[string]*version{},
+		names:    map[string]*Route{},
+		state:    newStateStack(),
+	}
 }
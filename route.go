@@ -1,13 +1,24 @@
 package router
 
-import "strings"
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
 
 // Route holds Route information.
 type Route struct {
-	method string
-	path   string
-	name   string
-	stack  []Handler
+	method      string
+	path        string
+	name        string
+	stack       []Handler
+	autoHead    bool
+	autoOptions bool
+	host        *host
+	version     *version
+	scheme      string
+	matchers    []matcher
+	repository  *repository
 }
 
 // Method returns route method.
@@ -26,22 +37,159 @@ func (r *Route) Name() string {
 }
 
 // SetName sets/updates route name.
+// It panics if another route already has the name.
 func (r *Route) SetName(name string) {
-	r.name = name
+	if err := r.repository.addName(name, r); err != nil {
+		panic(err)
+	}
+}
+
+// SetAutoHead enables/disables serving HEAD requests with this (GET) Route when no HEAD Route is declared.
+// It is enabled by default, and it only works if the router has it enabled too.
+func (r *Route) SetAutoHead(enabled bool) {
+	r.autoHead = enabled
+}
+
+// SetAutoOptions enables/disables answering OPTIONS requests automatically for the path of this Route.
+// It is enabled by default, and it only works if the router has it enabled too.
+func (r *Route) SetAutoOptions(enabled bool) {
+	r.autoOptions = enabled
+}
+
+// Header adds a constraint, so the Route only matches requests with the given header value.
+// Routes with the same method and path are tried in the order of definition, and the first one whose constraints
+// match the request handles it. If none matches, the router responds with 404.
+func (r *Route) Header(key, value string) *Route {
+	r.matchers = append(r.matchers, headerMatcher(key, value))
+	return r
+}
+
+// Query adds a constraint, so the Route only matches requests with the given query string parameter value.
+// If no Route with the same method and path matches the request, the router responds with 404.
+func (r *Route) Query(key, value string) *Route {
+	r.matchers = append(r.matchers, queryMatcher(key, value))
+	return r
+}
+
+// Scheme adds a constraint, so the Route only matches requests with the given URL scheme (http or https).
+// The absolute URLs of the Route (with a host) use the scheme too.
+// If no Route with the same method and path matches the request, the router responds with 404.
+func (r *Route) Scheme(scheme string) *Route {
+	r.matchers = append(r.matchers, schemeMatcher(scheme))
+	r.scheme = strings.ToLower(scheme)
+	return r
+}
+
+// Consumes adds a constraint, so the Route only matches requests with one of the given content types.
+// The media types could be ranges like `text/*`.
+// If no Route with the same method and path matches the request, the router responds with 415.
+func (r *Route) Consumes(mediaTypes ...string) *Route {
+	r.matchers = append(r.matchers, consumesMatcher(mediaTypes))
+	return r
+}
+
+// Produces adds a constraint, so the Route only matches requests that accept one of the given media types.
+// If no Route with the same method and path matches the request, the router responds with 406.
+func (r *Route) Produces(mediaTypes ...string) *Route {
+	r.matchers = append(r.matchers, producesMatcher(mediaTypes))
+	return r
+}
+
+// match checks the Route constraints against the request.
+// It returns zero if the request satisfies them all, and the status of the first failed stage otherwise.
+func (r *Route) match(request *http.Request) int {
+	for _, status := range stages {
+		for _, m := range r.matchers {
+			if m.status == status && !m.match(request) {
+				return status
+			}
+		}
+	}
+	return 0
 }
 
 // URL generate URL from route path with given parameters.
+// It escapes the parameter values, and the value of a named wildcard may contain multiple parts (slashes).
+// It generates an absolute URL for routes with a host, which takes its parameters from the same map.
+// It adds the version prefix to the URLs of versioned routes if the router resolves versions from path prefixes.
+// It omits the optional parameters that are not given (and the parts after them),
+// and keeps the other parameters that are not given as they are.
 func (r *Route) URL(parameters map[string]string) string {
-	uri := r.path
-	if parameters != nil {
-		for name, value := range parameters {
-			uri = strings.Replace(uri, ":"+name, value, 1)
+	parts := strings.Split(r.path, "/")
+	for i, part := range parts {
+		if part, optional := parseOptional(part); optional {
+			name, _, _ := parseParameter(part)
+			value, exist := parameters[name]
+			if !exist {
+				parts = parts[:i]
+				break
+			}
+			parts[i] = url.PathEscape(value)
+		} else if name, ok := parseWildcard(part); ok {
+			if value, exist := parameters[name]; name != "" && exist {
+				parts[i] = escapeParts(value)
+			}
+		} else if tokens, err := parseSegment(part); err == nil {
+			var b strings.Builder
+			for _, tk := range tokens {
+				if value, exist := parameters[tk.parameter]; tk.parameter != "" && exist {
+					b.WriteString(url.PathEscape(value))
+				} else {
+					b.WriteString(tk.text)
+				}
+			}
+			parts[i] = b.String()
+		}
+	}
+
+	uri := strings.Join(parts, "/")
+	if uri == "" {
+		uri = "/"
+	}
+
+	if r.version != nil && r.repository.versioning.Prefix {
+		if uri == "/" {
+			uri = ""
+		}
+		uri = "/v" + r.version.name + uri
+	}
+
+	if r.host != nil {
+		scheme := "http"
+		if r.scheme != "" {
+			scheme = r.scheme
 		}
+		return scheme + "://" + r.host.url(parameters) + uri
 	}
 	return uri
 }
 
+// escapeParts escapes the parts of the given (multipart) value and keeps the slashes between them.
+func escapeParts(value string) string {
+	parts := strings.Split(value, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// String returns the route method, host (if any), path, and version (if any).
+func (r *Route) String() string {
+	path := r.path
+	if r.host != nil {
+		path = r.host.pattern + path
+	}
+	if r.version != nil {
+		path += " (v" + r.version.name + ")"
+	}
+
+	if _, _, ok := parseParameter(r.method); ok {
+		return "ANY " + path
+	}
+	return r.method + " " + path
+}
+
 // newRoute creates a new Route instance.
-func newRoute(method, path string, stack []Handler) *Route {
-	return &Route{method, path, "", stack}
+func newRoute(repository *repository, method, path string, stack []Handler) *Route {
+	return &Route{method, path, "", stack, true, true, nil, nil, "", nil, repository}
 }
@@ -4,7 +4,11 @@
 package router
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"os"
+	"time"
 )
 
 // Router is the entry point of the package.
@@ -13,23 +17,42 @@ import (
 type Router struct {
 	repository *repository
 	director   *director
+	lifecycle  *lifecycle
 }
 
 // Define assigns a regular expression pattern to a Route parameter.
 // After the definition, the router only dispatches the related Route if the request URI matches the pattern.
+// The pattern is compiled once, and it panics if the pattern is not a valid regular expression.
 func (r Router) Define(parameter, pattern string) {
-	r.repository.addParameterPattern(parameter, pattern)
+	if err := r.repository.addParameterPattern(parameter, pattern); err != nil {
+		panic(err)
+	}
 }
 
 // Files defines a new static file server on the given path (URI) for the given directory root.
-// The path (URI) must end with `*` to cover all the existing files and subdirectories.
+// The path (URI) must end with a wildcard (`*` or `*name`) to cover all the existing files and subdirectories.
+// An unnamed wildcard is named `filepath`, so the Route path ends with `*filepath`.
 func (r Router) Files(path, directory string) *Route {
-	return r.GET(path, filesHandler(path, directory))
+	path, wildcard := filesPath(path)
+	return r.GET(path, filesHandler(wildcard, directory))
 }
 
 // Map defines a new Route by HTTP method and path and assigns a handler.
 // The path (URI) may contain Route parameters.
+// It panics if the path is invalid or conflicts with the existing routes, see MapE.
 func (r Router) Map(method, path string, handler Handler) *Route {
+	route, err := r.MapE(method, path, handler)
+	if err != nil {
+		panic(err)
+	}
+	return route
+}
+
+// MapE defines a new Route by HTTP method and path and assigns a handler, like Map.
+// It returns an error if the path is invalid or conflicts with the existing routes.
+// A conflicting Route has the same method and path as an existing one, or a parameter with the same pattern but
+// a different name at the same position. The existing routes remain intact on errors.
+func (r Router) MapE(method, path string, handler Handler) (*Route, error) {
 	return r.repository.addRoute(method, path, handler)
 }
 
@@ -39,6 +62,59 @@ func (r Router) Group(prefix string, middleware []Middleware, body func()) {
 	r.repository.addGroup(prefix, middleware, body)
 }
 
+// Mount attaches the handler to the given prefix, so it handles the requests to the prefix and all the paths
+// under it, whatever their methods are. It removes the prefix from the request paths before passing them to the
+// handler, and runs the current group middlewares first. If the handler is another Router, the named routes of
+// both routers are available to the URL generation of each other, with the prefix for the mounted ones.
+// It panics if the prefix is invalid or conflicts with the existing routes.
+func (r Router) Mount(prefix string, handler http.Handler) {
+	r.NewGroup("").Mount(prefix, handler)
+}
+
+// NewGroup creates a group of routes with the given prefix and middlewares, and returns it to register the routes.
+// The group inherits the attributes of the current (callback) group, if there is any.
+// Unlike the callback groups, it could be passed to other modules, and different groups could register their routes
+// concurrently.
+func (r Router) NewGroup(prefix string, middlewares ...Middleware) *Group {
+	return newGroup(r.repository, r.repository.state.current().extend(prefix, middlewares))
+}
+
+// Host creates a group of routes that only match requests to the hosts matching the given pattern.
+// The pattern may contain parameters, like `{tenant}.example.com` or `{tenant:[a-z]+}.example.com`, and the request
+// host parameters appear in the Context parameters. The router tries the hosts in the order of definition, then the
+// routes without hosts. It panics if the pattern is invalid.
+func (r Router) Host(pattern string, body func()) {
+	if err := r.repository.addHost(pattern, body); err != nil {
+		panic(err)
+	}
+}
+
+// Version creates a group of routes with the given API version, like `2`, `v2`, or `2.1`.
+// The router resolves the version of requests as configured by SetVersioning, and for each request URI, it picks
+// the Route with the latest version that is not after the resolved one. Routes without versions come before all
+// versions. The routes of different versions could name their parameters differently, like `/users/:id` in one
+// and `/users/:uid` in another. It panics if the version is invalid.
+func (r Router) Version(version string, body func()) {
+	if err := r.repository.addVersion(version, body); err != nil {
+		panic(err)
+	}
+}
+
+// DeprecateVersion marks the API version as deprecated, so the requests for it get the Deprecation header
+// and the Sunset header (if the sunset date is given), even if the routes of older versions serve them. The dates could be zero if they are unknown.
+// It panics if the version is invalid.
+func (r Router) DeprecateVersion(version string, deprecation, sunset time.Time) {
+	if err := r.repository.deprecateVersion(version, deprecation, sunset); err != nil {
+		panic(err)
+	}
+}
+
+// SetVersioning determines how the router resolves the API version of requests.
+// The latest version handles the requests without any resolved version.
+func (r Router) SetVersioning(versioning Versioning) {
+	r.repository.versioning = versioning
+}
+
 // WithPrefix creates a group of routes with common content.
 func (r Router) WithPrefix(prefix string, body func()) {
 	r.Group(prefix, []Middleware{}, body)
@@ -54,6 +130,15 @@ func (r Router) WithMiddlewares(middleware []Middleware, body func()) {
 	r.Group("", middleware, body)
 }
 
+// Use adds server-level middlewares that wrap the whole router, unlike the route middlewares (like AddMiddleware).
+// They run for all requests before routing, including the ones that lead to the 404, 405, and error handlers.
+// So they could rewrite the requests (see Context.SetRequest), and wrap the contexts (if the wrappers keep their
+// ResponseWriter). The errors they return go to the application error handler.
+// The router wraps each middleware once, and it should be called before serving the requests.
+func (r Router) Use(middlewares ...Middleware) {
+	r.director.use(middlewares)
+}
+
 // AddPrefix adds a global content for next or all routes.
 func (r Router) AddPrefix(prefix string) {
 	r.repository.updateGroup(prefix, []Middleware{})
@@ -75,15 +160,153 @@ func (r Router) SetNotFoundHandler(handler Handler) {
 	r.director.notFoundHandler = handler
 }
 
+// SetMethodNotAllowedHandler receives a handler and runs it when user request URI matches some declared routes,
+// but none of them accepts the request method.
+// It is the application 405 error handler, indeed. The Allow header is already set when the handler runs.
+func (r Router) SetMethodNotAllowedHandler(handler Handler) {
+	r.director.methodNotAllowedHandler = handler
+}
+
+// SetErrorHandler receives a handler and runs it when handlers (including the 404 and 405 handlers) return errors.
+// It is the application error handler, and DefaultErrorHandler (or ProblemErrorHandler, if problem details are
+// enabled) is the default one.
+func (r Router) SetErrorHandler(handler ErrorHandler) {
+	r.director.errorHandler = handler
+}
+
+// SetAutoHead enables/disables serving HEAD requests with the GET routes when no HEAD Route is declared.
+// The response body is discarded. It is enabled by default.
+func (r Router) SetAutoHead(enabled bool) {
+	r.director.autoHead = enabled
+}
+
+// SetAutoOptions enables/disables answering OPTIONS requests automatically when no OPTIONS Route is declared.
+// The response has no body, and its Allow header lists the methods declared for the request URI.
+// It is enabled by default.
+func (r Router) SetAutoOptions(enabled bool) {
+	r.director.autoOptions = enabled
+}
+
+// SetTrailingSlashPolicy determines how the router treats request URIs that only lead to a Route with (or without)
+// a trailing slash when it's removed (or added). It is PolicyStrict by default.
+func (r Router) SetTrailingSlashPolicy(policy PathPolicy) {
+	r.director.trailingSlash = policy
+}
+
+// SetDuplicateSlashPolicy determines how the router treats request URIs with duplicate slashes (like `/a//b`).
+// The canonical form has single slashes instead. It is PolicyStrict by default.
+func (r Router) SetDuplicateSlashPolicy(policy PathPolicy) {
+	r.director.duplicateSlashes = policy
+}
+
+// SetDotSegmentPolicy determines how the router treats request URIs with dot segments (like `/a/../b` or `/a/./b`).
+// The canonical form has the dot segments resolved. It is PolicyStrict by default.
+func (r Router) SetDotSegmentPolicy(policy PathPolicy) {
+	r.director.dotSegments = policy
+}
+
+// SetCaseInsensitive enables/disables matching the static parts of Route paths regardless of the case of their
+// ASCII letters. Parameter values keep their original case. It is disabled by default.
+func (r Router) SetCaseInsensitive(enabled bool) {
+	r.repository.setInsensitive(enabled)
+}
+
+// SetPanicReporter receives a reporter and runs it when handlers (including the 404 and 405 handlers) panic.
+// The router recovers the panics and handles them as PanicError errors with the application error handler,
+// unless the handlers have written the responses already. The reporter runs first, to log or report the panics.
+func (r Router) SetPanicReporter(reporter PanicReporter) {
+	r.director.panicReporter = reporter
+}
+
+// SetProblemDetails enables/disables responding with problem details (RFC 7807) by the default 404, 405, and error
+// handlers. Then, they respond with `application/problem+json` documents instead of the JSON messages, and the
+// default error handler works like ProblemErrorHandler. It is disabled by default.
+func (r Router) SetProblemDetails(enabled bool) {
+	r.director.problems = enabled
+}
+
+// SetEscapedPath enables/disables matching the routes against the escaped form of request URI paths.
+// Then, an escaped slash (`%2F`) doesn't separate path parts, and the router unescapes each parameter value
+// after matching. The static parts of Route paths must be in the escaped form too. It is disabled by default.
+func (r Router) SetEscapedPath(enabled bool) {
+	r.director.escapedPath = enabled
+}
+
 // Start runs the HTTP listener and waits for HTTP requests.
 // It should be called after definitions of routes.
+// It returns nil when the router shuts down gracefully, see Shutdown.
 func (r Router) Start(address string) error {
-	return http.ListenAndServe(address, r.director)
+	return r.StartServer(&http.Server{Addr: address})
+}
+
+// StartTLS runs the HTTPS listener with the given certificate and key files and waits for HTTPS requests.
+// It returns nil when the router shuts down gracefully, see Shutdown.
+func (r Router) StartTLS(address, certFile, keyFile string) error {
+	server := &http.Server{Addr: address, Handler: r.Handler()}
+	return r.lifecycle.serve(server, func() error {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+// StartServer runs the given HTTP server and waits for HTTP requests.
+// The server could have custom timeouts, TLS configurations, etc., and the router becomes its handler if it has none.
+// It listens for HTTPS requests if the TLS configuration has any certificate.
+// It returns nil when the router shuts down gracefully, see Shutdown.
+func (r Router) StartServer(server *http.Server) error {
+	if server.Handler == nil {
+		server.Handler = r.Handler()
+	}
+
+	return r.lifecycle.serve(server, func() error {
+		if c := server.TLSConfig; c != nil && (len(c.Certificates) > 0 || c.GetCertificate != nil) {
+			return server.ListenAndServeTLS("", "")
+		}
+		return server.ListenAndServe()
+	})
+}
+
+// Listen accepts HTTP connections on the given listener (like a Unix socket listener) and waits for HTTP requests.
+// It returns nil when the router shuts down gracefully, see Shutdown.
+func (r Router) Listen(listener net.Listener) error {
+	server := &http.Server{Handler: r.Handler()}
+	return r.lifecycle.serve(server, func() error {
+		return server.Serve(listener)
+	})
+}
+
+// Shutdown shuts the running servers of the router down gracefully.
+// The servers stop accepting new connections and wait for the in-flight requests until the context is done.
+// It returns the context error if the context is done before the in-flight requests.
+// The router doesn't start any server after the shutdown, and the start methods return nil immediately.
+func (r Router) Shutdown(ctx context.Context) error {
+	return r.lifecycle.shutdown(ctx)
+}
+
+// SetShutdownSignals enables shutting down the servers gracefully when the process receives any of the given signals
+// (like os.Interrupt and syscall.SIGTERM). The servers wait for the in-flight requests up to the given timeout
+// (or forever if it's zero), then the start methods return. It should be called before starting the servers.
+func (r Router) SetShutdownSignals(timeout time.Duration, signals ...os.Signal) {
+	r.lifecycle.mutex.Lock()
+	defer r.lifecycle.mutex.Unlock()
+
+	r.lifecycle.signals, r.lifecycle.timeout = signals, timeout
+}
+
+// ServeHTTP handles the request, so the router is a http.Handler.
+// It plugs into http.Server, httptest.NewServer, third-party middlewares, and other routers (see Mount).
+func (r Router) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
+	r.director.ServeHTTP(rw, request)
+}
+
+// Handler returns the router as a http.Handler.
+func (r Router) Handler() http.Handler {
+	return r
 }
 
 // Serve handles the request manually with a given request and a response writer.
+// It is the same as ServeHTTP.
 func (r Router) Serve(rw http.ResponseWriter, request *http.Request) {
-	r.director.ServeHTTP(rw, request)
+	r.ServeHTTP(rw, request)
 }
 
 // Any maps a method-agnostic Route.
@@ -130,5 +353,5 @@ func (r Router) OPTIONS(path string, handler Handler) *Route {
 func New() *Router {
 	repository := newRepository()
 	director := newDirector(repository)
-	return &Router{repository, director}
+	return &Router{repository, director, &lifecycle{}}
 }
@@ -1,13 +1,27 @@
 package router_test
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"github.com/golobby/router"
+	"github.com/golobby/router/pkg/problem"
 	"github.com/golobby/router/pkg/response"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -25,8 +39,8 @@ func (r *responseWriter) WriteHeader(statusCode int) {
 }
 
 func (r *responseWriter) Write(body []byte) (int, error) {
-	r.body = body
-	return 0, nil
+	r.body = append(r.body, body...)
+	return len(body), nil
 }
 
 func (r *responseWriter) Header() http.Header {
@@ -48,11 +62,67 @@ func newResponse() *responseWriter {
 // Testing HTTP request builder
 
 func newRequest(method, path string) *http.Request {
-	return &http.Request{
+	request := &http.Request{
 		Method:     method,
 		RequestURI: path,
-		URL:        &url.URL{Path: path},
 	}
+
+	// Like net/http, it parses the request URI, but it leaves the URL nil for invalid ones.
+	if u, err := url.ParseRequestURI(path); err == nil {
+		request.URL = u
+	}
+
+	return request
+}
+
+// Testing servers
+
+// freeAddress finds a free local TCP address for the servers.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitForBody requests the URL until the server starts, and returns the response body.
+func waitForBody(t *testing.T, client *http.Client, url string) string {
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		response, err := client.Get(url)
+		if err == nil {
+			body, _ := io.ReadAll(response.Body)
+			_ = response.Body.Close()
+			return string(body)
+		}
+		if time.Now().After(deadline) {
+			assert.Fail(t, err.Error())
+			return ""
+		}
+	}
+}
+
+// tlsFiles writes the certificate and key of httptest TLS servers (for 127.0.0.1) to files.
+// It returns the file paths, and a client that trusts the certificate.
+func tlsFiles(t *testing.T) (string, string, *http.Client) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	certificate := server.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	assert.NoError(t, err)
+
+	directory := t.TempDir()
+	certFile, keyFile := filepath.Join(directory, "cert.pem"), filepath.Join(directory, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	assert.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	assert.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	return certFile, keyFile, client
 }
 
 // Common values
@@ -108,7 +178,8 @@ func TestRouter_With_Different_HTTP_Methods(t *testing.T) {
 
 	rw := newResponse()
 	r.Serve(rw, newRequest("FAIL", "/"))
-	assert.Equal(t, 404, rw.status)
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "CUSTOM, DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT", rw.Header().Get("Allow"))
 }
 
 func TestRouter_With_Route_Parameters(t *testing.T) {
@@ -199,6 +270,233 @@ func TestRouter_With_Route_Parameters(t *testing.T) {
 	assert.Equal(t, "", rw.stringBody())
 }
 
+func TestRouter_Define_With_Invalid_Pattern(t *testing.T) {
+	r := router.New()
+
+	assert.PanicsWithError(t, `router: invalid pattern "[0-9" for parameter "id": `+
+		"error parsing regexp: missing closing ]: `[0-9)$`", func() {
+		r.Define("id", "[0-9")
+	})
+}
+
+func TestRouter_With_Alternation_Pattern(t *testing.T) {
+	r := router.New()
+	r.Define("format", "json|xml")
+	r.GET("/reports/:format", func(c router.Context) error {
+		return c.Text(200, c.Parameter("format"))
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/reports/xml"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "xml", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/reports/jsonxml"))
+	assert.Equal(t, 404, rw.status)
+}
+
+func TestRouter_With_Inline_Parameter_Patterns(t *testing.T) {
+	handler := func(c router.Context) error {
+		return c.Text(200, c.Route().Path()+" "+c.Parameter("id"))
+	}
+
+	r := router.New()
+	r.GET("/users/{id:[0-9]{2}}", handler).SetName("users")
+	r.GET("/users/:id<alpha>", handler)
+	r.GET("/users/:id", handler)
+	r.GET("/posts/{id:uuid}", handler)
+	r.GET("/posts/{id}", handler)
+	r.GET("/tags/:id<slug>", handler)
+	r.GET("/days/:id<date>", handler)
+	r.GET("/pages/:id<int>", handler).SetName("pages")
+
+	paths := map[string]string{
+		"/users/13":  "/users/{id:[0-9]{2}} 13",
+		"/users/abc": "/users/:id<alpha> abc",
+		"/users/133": "/users/:id 133",
+		"/users/a-1": "/users/:id a-1",
+		"/posts/123e4567-e89b-12d3-a456-426614174000": "/posts/{id:uuid} 123e4567-e89b-12d3-a456-426614174000",
+		"/posts/13":        "/posts/{id} 13",
+		"/tags/go-lang":    "/tags/:id<slug> go-lang",
+		"/days/2021-10-17": "/days/:id<date> 2021-10-17",
+		"/pages/13":        "/pages/:id<int> 13",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+
+	for _, path := range []string{"/tags/Go_Lang", "/days/2021-13-01", "/pages/abc"} {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 404, rw.status, path)
+	}
+
+	r.GET("/url", func(c router.Context) error {
+		return c.Text(200, c.URL("users", map[string]string{"id": "13"})+" "+c.URL("pages", nil))
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/url"))
+	assert.Equal(t, "/users/13 /pages/:id<int>", rw.stringBody())
+
+	assert.PanicsWithError(t, `router: invalid pattern "[0-9" for parameter "id": `+
+		"error parsing regexp: missing closing ]: `[0-9)$`", func() {
+		r.GET("/invalid/{id:[0-9}", handler)
+	})
+}
+
+func TestRouter_With_Optional_Parameters(t *testing.T) {
+	handler := func(c router.Context) error {
+		return c.JSON(200, c.Parameters())
+	}
+
+	r := router.New()
+	r.GET("/posts/:page<int>?", handler).SetName("posts")
+	r.GET("/archive/:year/{month}?/:day?", handler).SetName("archive")
+	r.GET("/:lang?", handler).SetName("home")
+	r.GET("/url", func(c router.Context) error {
+		return c.Text(200, strings.Join([]string{
+			c.URL("posts", nil),
+			c.URL("posts", map[string]string{"page": "2"}),
+			c.URL("archive", map[string]string{"year": "2021"}),
+			c.URL("archive", map[string]string{"year": "2021", "month": "10", "day": "17"}),
+			c.URL("archive", map[string]string{"year": "2021", "day": "17"}),
+			c.URL("home", nil),
+		}, " "))
+	})
+
+	paths := map[string]string{
+		"/posts":              `{}`,
+		"/posts/2":            `{"page":"2"}`,
+		"/archive/2021":       `{"year":"2021"}`,
+		"/archive/2021/10":    `{"month":"10","year":"2021"}`,
+		"/archive/2021/10/17": `{"day":"17","month":"10","year":"2021"}`,
+		"/":                   `{}`,
+		"/en":                 `{"lang":"en"}`,
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/posts/two"))
+	assert.Equal(t, 404, rw.status)
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/url"))
+	assert.Equal(t, "/posts /posts/2 /archive/2021 /archive/2021/10/17 /archive/2021 /", rw.stringBody())
+
+	assert.PanicsWithError(t, `router: optional parameters must be at the end of the path "/:a?/:b"`, func() {
+		r.GET("/:a?/:b", handler)
+	})
+}
+
+func TestRouter_With_Embedded_Parameters(t *testing.T) {
+	handler := func(c router.Context) error {
+		return c.JSON(200, c.Parameters())
+	}
+
+	r := router.New()
+	r.GET("/files/:name.json", handler).SetName("json")
+	r.GET("/files/:name", handler)
+	r.GET("/v:version/users", handler).SetName("users")
+	r.GET("/img/:w-x-:h<int>.png", handler).SetName("image")
+	r.GET("/archive/{year:int}-{month}", handler)
+	r.GET("/url", func(c router.Context) error {
+		return c.Text(200, strings.Join([]string{
+			c.URL("json", map[string]string{"name": "config"}),
+			c.URL("users", map[string]string{"version": "2"}),
+			c.URL("image", map[string]string{"w": "640", "h": "480"}),
+			c.URL("image", map[string]string{"w": "640"}),
+		}, " "))
+	})
+
+	paths := map[string]string{
+		"/files/config.json":    `{"name":"config"}`,
+		"/files/config.v2.json": `{"name":"config.v2"}`,
+		"/files/config.xml":     `{"name":"config.xml"}`,
+		"/v2/users":             `{"version":"2"}`,
+		"/img/640-x-480.png":    `{"h":"480","w":"640"}`,
+		"/img/a-x-b-x-480.png":  `{"h":"480","w":"a-x-b"}`,
+		"/archive/2021-10":      `{"month":"10","year":"2021"}`,
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+
+	for _, path := range []string{"/v2/posts", "/img/640-x-big.png", "/archive/twenty-10"} {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 404, rw.status, path)
+	}
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/url"))
+	assert.Equal(t, "/files/config.json /v2/users /img/640-x-480.png /img/640-x-:h<int>.png", rw.stringBody())
+
+	assert.PanicsWithError(t, `router: parameters must be separated by static text in ":a{b}"`, func() {
+		r.GET("/:a{b}", handler)
+	})
+	assert.PanicsWithError(t, `router: unclosed parameter in "{a"`, func() {
+		r.GET("/{a", handler)
+	})
+}
+
+func TestRouter_With_Conflicting_Routes(t *testing.T) {
+	handler := func(c router.Context) error {
+		return c.Text(200, c.Route().Path())
+	}
+
+	r := router.New()
+	r.Define("number", "[0-9a-f]+")
+	r.GET("/users/:id", handler)
+	r.GET("/users/:number", handler)
+	r.GET("/users/:id<int>", handler)
+	r.GET("/posts/:page?", handler)
+	r.Any("/users/:id", handler)
+
+	conflicts := map[string]string{
+		"/users/{id}":          "router: route GET /users/{id} conflicts with route GET /users/:id",
+		"/users/:name":         `router: parameter "name" of route GET /users/:name is ambiguous with parameter "id" of route GET /users/:id`,
+		"/users/{n:[0-9a-f]+}": `router: parameter "n" of route GET /users/{n:[0-9a-f]+} is ambiguous with parameter "number" of route GET /users/:number`,
+		"/users/{n:int}":       `router: parameter "n" of route GET /users/{n:int} is ambiguous with parameter "id" of route GET /users/:id<int>`,
+		"/posts":               "router: route GET /posts conflicts with route GET /posts/:page?",
+		"/:a/:b?":              "",
+	}
+
+	for path, message := range conflicts {
+		route, err := r.MapE("GET", path, handler)
+		if message == "" {
+			assert.NoError(t, err)
+			assert.Equal(t, path, route.Path())
+		} else {
+			assert.EqualError(t, err, message, path)
+			assert.Nil(t, route)
+		}
+	}
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/posts"))
+	assert.Equal(t, "/posts/:page?", rw.stringBody())
+
+	assert.PanicsWithError(t, "router: route GET /:a/:b conflicts with route GET /:a/:b?", func() {
+		r.GET("/:a/:b", handler)
+	})
+}
+
 func TestRouter_With_Both_Static_Part_And_Parameter(t *testing.T) {
 	r := router.New()
 
@@ -275,142 +573,606 @@ func TestRouter_With_Wildcard(t *testing.T) {
 	assert.Equal(t, "wildcard-all", rw.stringBody())
 }
 
-func TestRouter_With_Context_Parameters(t *testing.T) {
+func TestRouter_With_Matching_Priority(t *testing.T) {
+	handler := func(c router.Context) error {
+		return c.Text(200, c.Route().Path())
+	}
+
 	r := router.New()
-	r.GET("/", func(c router.Context) error {
-		return c.Text(200, c.Route().Method()+" "+c.Route().Path())
-	})
+	r.Define("number", "[0-9]+")
+	r.GET("/*", handler)
+	r.GET("/users/:id", handler)
+	r.GET("/users/:number", handler)
+	r.GET("/users/me", handler)
+	r.GET("/users/me/profile", handler)
+	r.GET("/posts/:id/comments", handler)
+	r.GET("/posts/latest/likes", handler)
+
+	paths := map[string]string{
+		"/users/me":              "/users/me",
+		"/users/13":              "/users/:number",
+		"/users/john":            "/users/:id",
+		"/users/me/profile":      "/users/me/profile",
+		"/users/me/settings":     "/*",
+		"/posts/latest/comments": "/posts/:id/comments",
+		"/posts/latest/likes":    "/posts/latest/likes",
+		"/posts":                 "/*",
+	}
 
-	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "GET /", rw.stringBody())
+	for path, route := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status)
+		assert.Equal(t, route, rw.stringBody(), path)
+	}
 }
 
-func TestRouter_With_File_Response(t *testing.T) {
-	r := router.New()
-	r.GET("/", func(c router.Context) error {
-		return c.File(200, "text/plain", "assets/text.txt")
-	})
-	r.GET("/404", func(c router.Context) error {
-		return c.File(200, "text/plain", "assets/no-file")
-	})
+func TestRouter_With_Shared_Prefixes(t *testing.T) {
+	handler := func(c router.Context) error {
+		return c.Text(200, c.Route().Path()+" "+c.Parameter("id"))
+	}
 
-	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "This is a text file.", rw.stringBody())
+	r := router.New()
+	for _, path := range []string{"/users", "/u", "/user", "/users/:id", "/user-:id", "/usage", "/u/:id/x", "/u/:id"} {
+		r.GET(path, handler)
+	}
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/404"))
-	assert.Equal(t, 500, rw.status)
-}
+	paths := map[string]string{
+		"/users":   "/users ",
+		"/u":       "/u ",
+		"/user":    "/user ",
+		"/usage":   "/usage ",
+		"/users/1": "/users/:id 1",
+		"/user-2":  "/user-:id 2",
+		"/u/3/x":   "/u/:id/x 3",
+		"/u/4":     "/u/:id 4",
+	}
 
-func TestRouter_With_Serving_Static_Files(t *testing.T) {
-	r := router.New()
-	r.Files("/files/notes/*", "assets/notes")
-	r.Files("/*", "assets")
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
 
-	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/files/notes/"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "<p>This is notes index.</p>", rw.stringBody())
+	for _, path := range []string{"/us", "/usersx", "/use", "/u/4/y"} {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 404, rw.status, path)
+	}
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/files/notes/note1.txt"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "This is note 1.", rw.stringBody())
+	assert.PanicsWithError(t, `router: wildcards must be at the end of the path "/files/*/x"`, func() {
+		r.GET("/files/*/x", handler)
+	})
+	assert.PanicsWithError(t, `router: wildcard "*b" of route GET /files/*b conflicts with wildcard "*a" of route GET /files/*a`, func() {
+		r.GET("/files/*a", handler)
+		r.GET("/files/*b", handler)
+	})
+}
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "<p>This is root index.</p>", rw.stringBody())
+func TestRouter_With_Named_Wildcard(t *testing.T) {
+	r := router.New()
+	r.GET("/files/*filepath", func(c router.Context) error {
+		return c.Text(200, c.Parameter("filepath"))
+	}).SetName("files")
+	r.GET("/users/:id/*rest", func(c router.Context) error {
+		return c.Text(200, c.Parameter("id")+" "+c.Parameter("rest"))
+	})
+	r.GET("/url", func(c router.Context) error {
+		return c.Text(200, c.URL("files", map[string]string{"filepath": "notes/note1.txt"}))
+	})
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/text.txt"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "This is a text file.", rw.stringBody())
+	paths := map[string]string{
+		"/files/":                "",
+		"/files/text.txt":        "text.txt",
+		"/files/notes/note1.txt": "notes/note1.txt",
+		"/users/13/":             "13 ",
+		"/users/13/posts/33":     "13 posts/33",
+		"/url":                   "/files/notes/note1.txt",
+	}
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/notes/"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "<p>This is notes index.</p>", rw.stringBody())
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
 }
 
-func TestRouter_With_Route_Names(t *testing.T) {
+func TestRouter_SetEscapedPath(t *testing.T) {
 	r := router.New()
-	r.GET("/", func(c router.Context) error {
-		return c.Text(200, c.URL("home", nil))
-	}).SetName("home")
-	r.GET("/single/:id", func(c router.Context) error {
-		return c.Text(200, c.URL("single", map[string]string{"id": "13"}))
-	}).SetName("single")
-	r.GET("/multi/:one/:two", func(c router.Context) error {
-		return c.Text(200, c.URL("multi", map[string]string{"one": "13", "two": "33"}))
-	}).SetName("multi")
-	r.GET("/else/:id", func(c router.Context) error {
-		return c.Text(200, c.URL("other", map[string]string{"id": "13"}))
-	}).SetName("else")
-	r.GET("/name", func(c router.Context) error {
-		return c.Text(200, c.Route().Name())
-	}).SetName("name")
+	r.GET("/users/:name", func(c router.Context) error {
+		return c.Text(200, c.Parameter("name"))
+	}).SetName("user")
+	r.GET("/docs/*path", func(c router.Context) error {
+		return c.Text(200, c.Parameter("path"))
+	}).SetName("docs")
+	r.GET("/url", func(c router.Context) error {
+		return c.Text(200, c.URL("user", map[string]string{"name": "a/b c"})+" "+
+			c.URL("docs", map[string]string{"path": "guide/a b?"}))
+	})
 
 	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "/", rw.stringBody())
+	r.Serve(rw, newRequest("GET", "/users/a%2Fb"))
+	assert.Equal(t, 404, rw.status)
 
 	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/single/1"))
+	r.Serve(rw, newRequest("GET", "/url"))
 	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "/single/13", rw.stringBody())
+	assert.Equal(t, "/users/a%2Fb%20c /docs/guide/a%20b%3F", rw.stringBody())
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/multi/1/2"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "/multi/13/33", rw.stringBody())
+	r.SetEscapedPath(true)
+
+	paths := map[string]string{
+		"/users/milad":           "milad",
+		"/users/a%2Fb":           "a/b",
+		"/users/a%2Fb%20c?q=1":   "a/b c",
+		"/users/100%25":          "100%",
+		"/docs/guide/a%20b%3F":   "guide/a b?",
+		"/docs/guide%2Finstall/": "guide/install/",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status, path)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
 
 	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/else/1"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "", rw.stringBody())
+	r.Serve(rw, newRequest("GET", "/users/%zz"))
+	assert.Equal(t, 404, rw.status)
+
+	r.GET("/files/:name/", func(c router.Context) error {
+		return c.Text(200, c.Parameter("name"))
+	})
+	r.SetTrailingSlashPolicy(router.PolicyRedirect)
 
 	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/name"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "name", rw.stringBody())
+	r.Serve(rw, newRequest("GET", "/files/a%2Fb%20c?q=1"))
+	assert.Equal(t, 301, rw.status)
+	assert.Equal(t, "/files/a%2Fb%20c/?q=1", rw.Header().Get("Location"))
 }
 
-func TestRouter_WithPrefix(t *testing.T) {
+func TestRouter_Host(t *testing.T) {
 	r := router.New()
-	r.WithPrefix("/path", func() {
-		r.WithPrefix("/to", func() {
-			r.GET("/page", func(c router.Context) error {
-				return c.Text(200, "Page1")
-			})
+	r.Host("api.example.com", func() {
+		r.GET("/users", func(c router.Context) error {
+			return c.Text(200, "api users")
 		})
-		r.GET("/page", func(c router.Context) error {
-			return c.Text(200, "Page2")
+	})
+	r.Host("{tenant}.example.com", func() {
+		r.WithPrefix("/admin", func() {
+			r.GET("/users/:id", func(c router.Context) error {
+				return c.Text(200, c.Parameter("tenant")+" "+c.Parameter("id"))
+			}).SetName("tenant-user")
 		})
 	})
-	r.GET("/page", func(c router.Context) error {
-		return c.Text(200, "Page3")
+	r.GET("/users", func(c router.Context) error {
+		return c.Text(200, "users")
+	})
+	r.GET("/url", func(c router.Context) error {
+		return c.Text(200, c.URL("tenant-user", map[string]string{"tenant": "acme", "id": "13"}))
 	})
 
+	hosts := map[string]string{
+		"api.example.com/users":             "api users",
+		"API.example.com:8080/users":        "api users",
+		"acme.example.com/users":            "users",
+		"acme.example.com/admin/users/13":   "acme 13",
+		"acme.example.com:80/admin/users/7": "acme 7",
+		"example.com/users":                 "users",
+		"example.com/url":                   "http://acme.example.com/admin/users/13",
+	}
+
+	for uri, body := range hosts {
+		i := strings.IndexByte(uri, '/')
+		request := newRequest("GET", uri[i:])
+		request.Host = uri[:i]
+
+		rw := newResponse()
+		r.Serve(rw, request)
+		assert.Equal(t, 200, rw.status, uri)
+		assert.Equal(t, body, rw.stringBody(), uri)
+	}
+
+	request := newRequest("GET", "/admin/users/13")
+	request.Host = "a.b.example.com"
 	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/path/to/page"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "Page1", rw.stringBody())
+	r.Serve(rw, request)
+	assert.Equal(t, 404, rw.status)
 
+	request = newRequest("POST", "/admin/users/13")
+	request.Host = "acme.example.com"
 	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/path/page"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "Page2", rw.stringBody())
+	r.Serve(rw, request)
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "GET, HEAD, OPTIONS", rw.Header().Get("Allow"))
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/page"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "Page3", rw.stringBody())
+	assert.PanicsWithError(t, "router: unclosed parameter in \"{tenant.example.com\"", func() {
+		r.Host("{tenant.example.com", func() {})
+	})
+}
+
+func TestRouter_With_Route_Constraints(t *testing.T) {
+	r := router.New()
+	r.GET("/reports", func(c router.Context) error {
+		return c.Text(200, "v2")
+	}).Header("X-Api-Version", "2")
+	r.GET("/reports", func(c router.Context) error {
+		return c.Text(200, "csv")
+	}).Query("format", "csv").Produces("text/csv")
+	r.GET("/reports", func(c router.Context) error {
+		return c.Text(200, "json")
+	}).Produces("application/json")
+	r.POST("/reports", func(c router.Context) error {
+		return c.Text(201, "created")
+	}).Consumes("application/json", "text/*")
+	r.GET("/secure", func(c router.Context) error {
+		return c.Text(200, "secure")
+	}).Scheme("https")
+
+	type test struct {
+		method, uri, header, value string
+		status                     int
+		body                       string
+	}
+
+	tests := []test{
+		{"GET", "/reports", "X-Api-Version", "2", 200, "v2"},
+		{"GET", "/reports?format=csv", "Accept", "text/csv", 200, "csv"},
+		{"GET", "/reports?format=csv", "Accept", "text/*", 200, "csv"},
+		{"GET", "/reports?format=csv", "Accept", "application/json", 200, "json"},
+		{"GET", "/reports", "Accept", "application/json, text/html;q=0.9", 200, "json"},
+		{"GET", "/reports", "", "", 200, "json"},
+		{"GET", "/reports", "Accept", "text/html", 406, "{\"message\":\"Not acceptable.\"}"},
+		{"GET", "/reports", "Accept", "application/json;q=0", 406, "{\"message\":\"Not acceptable.\"}"},
+		{"POST", "/reports", "Content-Type", "application/json; charset=utf-8", 201, "created"},
+		{"POST", "/reports", "Content-Type", "text/plain", 201, "created"},
+		{"POST", "/reports", "Content-Type", "application/xml", 415, "{\"message\":\"Unsupported media type.\"}"},
+		{"GET", "/secure", "", "", 404, "{\"message\":\"Not found.\"}"},
+		{"GET", "https://example.com/secure", "", "", 200, "secure"},
+	}
+
+	for _, tt := range tests {
+		request := newRequest(tt.method, tt.uri)
+		request.Header = http.Header{}
+		if tt.header != "" {
+			request.Header.Set(tt.header, tt.value)
+		}
+
+		rw := newResponse()
+		r.Serve(rw, request)
+		assert.Equal(t, tt.status, rw.status, tt.uri+" "+tt.value)
+		assert.Equal(t, tt.body, rw.stringBody(), tt.uri+" "+tt.value)
+	}
+
+	assert.PanicsWithError(t, "router: route GET /secure conflicts with route GET /secure", func() {
+		r.GET("/secure", func(c router.Context) error {
+			return c.Text(200, "insecure")
+		})
+		r.GET("/secure", func(c router.Context) error {
+			return c.Text(200, "another")
+		})
+	})
+}
+
+func TestRouter_Version(t *testing.T) {
+	r := router.New()
+	r.SetVersioning(router.Versioning{Prefix: true, Parameter: "version", Header: "X-Api-Version"})
+	r.DeprecateVersion("1", time.Unix(1633046400, 0), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	r.Version("v1", func() {
+		r.GET("/users", func(c router.Context) error {
+			return c.Text(200, "v1 users")
+		})
+		r.GET("/posts", func(c router.Context) error {
+			return c.Text(200, "v1 posts")
+		}).SetName("posts")
+	})
+	r.Version("2", func() {
+		r.GET("/users", func(c router.Context) error {
+			return c.Text(200, "v2 users")
+		}).SetName("users")
+		r.GET("/", func(c router.Context) error {
+			return c.Text(200, c.URL("users", nil)+" "+c.URL("posts", nil)+" "+c.URL("home", nil))
+		}).SetName("home")
+	})
+	r.GET("/health", func(c router.Context) error {
+		return c.Text(200, "healthy")
+	})
+
+	type test struct {
+		uri, header, value string
+		body               string
+	}
+
+	tests := []test{
+		{"/v1/users", "", "", "v1 users"},
+		{"/v2/users", "", "", "v2 users"},
+		{"/v3/users", "", "", "v2 users"},
+		{"/v2/posts", "", "", "v1 posts"},
+		{"/v1.5/posts", "", "", "v1 posts"},
+		{"/users", "", "", "v2 users"},
+		{"/users", "Accept", "application/json; version=1", "v1 users"},
+		{"/users", "X-Api-Version", "1", "v1 users"},
+		{"/v2/health", "", "", "healthy"},
+		{"/health", "", "", "healthy"},
+		{"/v2", "", "", "/v2/users /v1/posts /v2"},
+	}
+
+	for _, tt := range tests {
+		request := newRequest("GET", tt.uri)
+		request.Header = http.Header{}
+		if tt.header != "" {
+			request.Header.Set(tt.header, tt.value)
+		}
+
+		rw := newResponse()
+		r.Serve(rw, request)
+		assert.Equal(t, 200, rw.status, tt.uri)
+		assert.Equal(t, tt.body, rw.stringBody(), tt.uri)
+	}
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/v1/users"))
+	assert.Equal(t, "@1633046400", rw.Header().Get("Deprecation"))
+	assert.Equal(t, "Sat, 01 Jan 2022 00:00:00 GMT", rw.Header().Get("Sunset"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/v2/users"))
+	assert.Equal(t, "", rw.Header().Get("Deprecation"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/v2/posts"))
+	assert.Equal(t, "v1 posts", rw.stringBody())
+	assert.Equal(t, "", rw.Header().Get("Deprecation"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/v1/"))
+	assert.Equal(t, 404, rw.status)
+
+	assert.PanicsWithError(t, "router: invalid version \"latest\"", func() {
+		r.Version("latest", func() {})
+	})
+}
+
+func TestRouter_Version_With_Renamed_Parameters(t *testing.T) {
+	r := router.New()
+	r.SetVersioning(router.Versioning{Prefix: true})
+
+	r.Version("1", func() {
+		r.GET("/users/:id", func(c router.Context) error {
+			return c.Text(200, "v1 "+c.Parameter("id"))
+		})
+		r.GET("/files/*path", func(c router.Context) error {
+			return c.Text(200, "v1 "+c.Parameter("path"))
+		})
+	})
+	r.Version("2", func() {
+		r.GET("/users/:uid", func(c router.Context) error {
+			return c.Text(200, "v2 "+c.Parameter("uid")+" "+c.Parameter("id"))
+		})
+		r.GET("/files/*name", func(c router.Context) error {
+			return c.Text(200, "v2 "+c.Parameter("name"))
+		})
+	})
+
+	paths := map[string]string{
+		"/v1/users/13":  "v1 13",
+		"/v2/users/13":  "v2 13 ",
+		"/v1/files/a/b": "v1 a/b",
+		"/v2/files/a/b": "v2 a/b",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status, path)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+
+	assert.PanicsWithError(t, "router: parameter \"name\" of route GET /users/:name (v2) is ambiguous with parameter \"uid\" of route GET /users/:uid (v2)", func() {
+		r.Version("2", func() {
+			r.GET("/users/:name", func(c router.Context) error {
+				return nil
+			})
+		})
+	})
+}
+
+func TestRouter_DeprecateVersion_With_Fallback_Routes(t *testing.T) {
+	r := router.New()
+	r.SetVersioning(router.Versioning{Prefix: true})
+	r.DeprecateVersion("2", time.Time{}, time.Time{})
+
+	r.Version("1", func() {
+		r.GET("/users", func(c router.Context) error {
+			return c.Text(200, "v1 users")
+		})
+	})
+	r.Version("2", func() {})
+	r.Version("3", func() {})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/v2/users"))
+	assert.Equal(t, "v1 users", rw.stringBody())
+	assert.Equal(t, "true", rw.Header().Get("Deprecation"))
+
+	for _, path := range []string{"/v1/users", "/v3/users"} {
+		rw = newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, "v1 users", rw.stringBody(), path)
+		assert.Equal(t, "", rw.Header().Get("Deprecation"), path)
+	}
+}
+
+func TestRouter_With_Context_Parameters(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, c.Route().Method()+" "+c.Route().Path())
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "GET /", rw.stringBody())
+}
+
+func TestRouter_With_File_Response(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.File(200, "text/plain", "assets/text.txt")
+	})
+	r.GET("/404", func(c router.Context) error {
+		return c.File(200, "text/plain", "assets/no-file")
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "This is a text file.", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/404"))
+	assert.Equal(t, 500, rw.status)
+}
+
+func TestRouter_With_Serving_Static_Files(t *testing.T) {
+	r := router.New()
+	r.Files("/files/notes/*", "assets/notes")
+	r.Files("/docs/*path", "assets")
+	r.Files("/*", "assets")
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/files/notes/"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "<p>This is notes index.</p>", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/files/notes/note1.txt"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "This is note 1.", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "<p>This is root index.</p>", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/text.txt"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "This is a text file.", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/notes/"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "<p>This is notes index.</p>", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/docs/notes/note1.txt"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "This is note 1.", rw.stringBody())
+}
+
+func TestRouter_With_Route_Names(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, c.URL("home", nil))
+	}).SetName("home")
+	r.GET("/single/:id", func(c router.Context) error {
+		return c.Text(200, c.URL("single", map[string]string{"id": "13"}))
+	}).SetName("single")
+	r.GET("/multi/:one/:two", func(c router.Context) error {
+		return c.Text(200, c.URL("multi", map[string]string{"one": "13", "two": "33"}))
+	}).SetName("multi")
+	r.GET("/else/:id", func(c router.Context) error {
+		return c.Text(200, c.URL("other", map[string]string{"id": "13"}))
+	}).SetName("else")
+	r.GET("/name", func(c router.Context) error {
+		return c.Text(200, c.Route().Name())
+	}).SetName("name")
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "/", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/single/1"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "/single/13", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/multi/1/2"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "/multi/13/33", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/else/1"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/name"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "name", rw.stringBody())
+}
+
+func TestRouter_With_Duplicate_Route_Names(t *testing.T) {
+	handler := func(c router.Context) error {
+		return c.Text(200, c.URL("home", nil)+" "+c.URL("index", nil))
+	}
+
+	r := router.New()
+	route := r.GET("/", handler)
+	route.SetName("index")
+	route.SetName("index")
+	route.SetName("home")
+	r.Any("/any", handler).SetName("any")
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, "/ ", rw.stringBody())
+
+	assert.PanicsWithError(t, `router: name "home" of route POST /home is already taken by route GET /`, func() {
+		r.POST("/home", handler).SetName("home")
+	})
+	assert.PanicsWithError(t, `router: name "any" of route GET /other is already taken by route ANY /any`, func() {
+		r.GET("/other", handler).SetName("any")
+	})
+}
+
+func TestRouter_WithPrefix(t *testing.T) {
+	r := router.New()
+	r.WithPrefix("/path", func() {
+		r.WithPrefix("/to", func() {
+			r.GET("/page", func(c router.Context) error {
+				return c.Text(200, "Page1")
+			})
+		})
+		r.GET("/page", func(c router.Context) error {
+			return c.Text(200, "Page2")
+		})
+	})
+	r.GET("/page", func(c router.Context) error {
+		return c.Text(200, "Page3")
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/path/to/page"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "Page1", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/path/page"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "Page2", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/page"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "Page3", rw.stringBody())
 }
 
 func TestRouter_AddPrefix(t *testing.T) {
@@ -468,63 +1230,633 @@ func TestRouter_WithMiddlewares(t *testing.T) {
 	assert.Equal(t, "Middleware1 Middleware2", rw.stringBody())
 }
 
-func TestRouter_AddMiddlewares(t *testing.T) {
+func TestRouter_AddMiddlewares(t *testing.T) {
+	r := router.New()
+	r.AddMiddlewares([]router.Middleware{Middleware1, Middleware2})
+	r.GET("/", func(c router.Context) error {
+		b := c.Response().Header().Get("Middleware1") + " " + c.Response().Header().Get("Middleware2")
+		return c.Text(200, b)
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "Middleware1 Middleware2", rw.stringBody())
+}
+
+func TestRouter_NewGroup(t *testing.T) {
+	handler := func(c router.Context) error {
+		b := c.Response().Header().Get("Middleware1") + "," + c.Response().Header().Get("Middleware2")
+		return c.Text(200, c.Route().Path()+" "+b)
+	}
+
+	r := router.New()
+	r.AddPrefix("/api")
+
+	users := r.NewGroup("/users", Middleware1)
+	r.AddPrefix("/leaked")
+
+	users.GET("/", handler)
+	users.Use(Middleware2)
+	users.GET("/:id", handler)
+
+	admin := users.Group("/admin")
+	admin.GET("/stats", handler)
+	admin.Host("admin.example.com").GET("/reports", handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.NewGroup("/posts").GET("/"+strconv.Itoa(i), handler)
+		}(i)
+	}
+	wg.Wait()
+
+	paths := map[string]string{
+		"/api/users/":            "/api/users/ Middleware1,",
+		"/api/users/13":          "/api/users/:id Middleware1,Middleware2",
+		"/api/users/admin/stats": "/api/users/admin/stats Middleware1,Middleware2",
+		"/api/leaked/posts/7":    "/api/leaked/posts/7 ,",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status, path)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+
+	request := newRequest("GET", "/api/users/admin/reports")
+	request.Host = "admin.example.com"
+	rw := newResponse()
+	r.Serve(rw, request)
+	assert.Equal(t, 200, rw.status)
+}
+
+func TestRouter_Mount(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, c.URL("post", map[string]string{"id": "13"})+" "+
+			c.URL("tenant-post", map[string]string{"tenant": "acme", "id": "7"}))
+	}).SetName("home")
+
+	r.WithMiddleware(Middleware1, func() {
+		r.Mount("/debug", http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+			rw.WriteHeader(200)
+			_, _ = rw.Write([]byte(request.Method + " " + request.URL.Path + " " + request.RequestURI + " " +
+				rw.Header().Get("Middleware1")))
+		}))
+	})
+
+	blog := router.New()
+	blog.GET("/posts/:id", func(c router.Context) error {
+		return c.Text(200, c.Parameter("id")+" "+c.URL("home", nil)+" "+c.URL("post", map[string]string{"id": "1"}))
+	}).SetName("post")
+	r.Mount("/blog/", blog)
+
+	tenants := router.New()
+	tenants.GET("/posts/:id", func(c router.Context) error {
+		return c.Text(200, c.Parameter("id"))
+	}).SetName("tenant-post")
+	r.Mount("/tenants/:tenant", tenants)
+
+	paths := map[string]string{
+		"/":                      "/blog/posts/13 /tenants/acme/posts/7",
+		"/debug":                 "GET / / Middleware1",
+		"/debug/pprof/heap?gc=1": "GET /pprof/heap /pprof/heap?gc=1 Middleware1",
+		"/blog/posts/33":         "33 / /blog/posts/1",
+		"/tenants/acme/posts/7":  "7",
+	}
+
+	for path, body := range paths {
+		request := newRequest("GET", path)
+
+		rw := newResponse()
+		r.Serve(rw, request)
+		assert.Equal(t, 200, rw.status, path)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("DELETE", "/debug/vars"))
+	assert.Equal(t, "DELETE /vars /vars Middleware1", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/blog/comments"))
+	assert.Equal(t, 404, rw.status)
+
+	var parameters map[string]string
+	r.WithMiddleware(func(next router.Handler) router.Handler {
+		return func(c router.Context) error {
+			parameters = c.Parameters()
+			return next(c)
+		}
+	}, func() {
+		r.Mount("/shops/:shop", http.NotFoundHandler())
+	})
+
+	r.Serve(newResponse(), newRequest("GET", "/shops/s1/items"))
+	assert.Equal(t, map[string]string{"shop": "s1"}, parameters)
+}
+
+func TestRouter_Mount_With_Escaped_Paths(t *testing.T) {
+	r := router.New()
+	r.SetEscapedPath(true)
+
+	files := router.New()
+	files.SetEscapedPath(true)
+	files.GET("/files/:name", func(c router.Context) error {
+		return c.Text(200, c.Parameter("name"))
+	})
+	r.Mount("/storage", files)
+
+	r.Mount("/debug", http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		_, _ = rw.Write([]byte(request.URL.Path + " " + request.URL.EscapedPath() + " " + request.RequestURI))
+	}))
+
+	paths := map[string]string{
+		"/storage/files/a%2Fb": "a/b",
+		"/debug/a%2Fb%20c?q=1": "/a/b c /a%2Fb%20c /a%2Fb%20c?q=1",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status, path)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+}
+
+func TestRouter_SetNotFoundHandler(t *testing.T) {
+	r := router.New()
+
+	r.SetNotFoundHandler(func(c router.Context) error {
+		return c.Text(504, "New Not Found")
+	})
+
+	r.GET("/%", func(c router.Context) error {
+		return c.Text(200, "OK")
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, 504, rw.status)
+	assert.Equal(t, "New Not Found", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/%"))
+	assert.Equal(t, 504, rw.status)
+	assert.Equal(t, "New Not Found", rw.stringBody())
+}
+
+func TestRouter_Method_Not_Allowed(t *testing.T) {
+	r := router.New()
+	r.GET("/users/:id", func(c router.Context) error {
+		return c.Text(200, "GET")
+	})
+	r.DELETE("/users/:id", func(c router.Context) error {
+		return c.Text(200, "DELETE")
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("POST", "/users/13"))
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", rw.Header().Get("Allow"))
+	assert.Equal(t, "{\"message\":\"Method not allowed.\"}", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("POST", "/posts/13"))
+	assert.Equal(t, 404, rw.status)
+	assert.Equal(t, "", rw.Header().Get("Allow"))
+}
+
+func TestRouter_SetMethodNotAllowedHandler(t *testing.T) {
+	r := router.New()
+
+	r.SetMethodNotAllowedHandler(func(c router.Context) error {
+		return c.Text(405, "Allowed: "+c.Response().Header().Get("Allow"))
+	})
+
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, "OK")
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("PUT", "/"))
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "Allowed: GET, HEAD, OPTIONS", rw.stringBody())
+}
+
+func TestRouter_Auto_Head_And_Options(t *testing.T) {
+	r := router.New()
+	r.GET("/users", func(c router.Context) error {
+		c.Response().Header().Set("X-Count", "13")
+		return c.Text(200, "users")
+	})
+	r.POST("/users", func(c router.Context) error {
+		return c.Text(201, "created")
+	})
+	r.GET("/posts", func(c router.Context) error {
+		return c.Text(200, "posts")
+	}).SetAutoHead(false)
+	r.GET("/comments", func(c router.Context) error {
+		return c.Text(200, "comments")
+	}).SetAutoOptions(false)
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("HEAD", "/users"))
+	assert.Equal(t, 200, rw.status)
+	assert.Equal(t, "", rw.stringBody())
+	assert.Equal(t, "13", rw.Header().Get("X-Count"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("OPTIONS", "/users"))
+	assert.Equal(t, 204, rw.status)
+	assert.Equal(t, "GET, HEAD, OPTIONS, POST", rw.Header().Get("Allow"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("HEAD", "/posts"))
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "GET, OPTIONS", rw.Header().Get("Allow"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("OPTIONS", "/comments"))
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "GET, HEAD", rw.Header().Get("Allow"))
+
+	r.SetAutoHead(false)
+	r.SetAutoOptions(false)
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("HEAD", "/users"))
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "GET, POST", rw.Header().Get("Allow"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("OPTIONS", "/users"))
+	assert.Equal(t, 405, rw.status)
+}
+
+func TestRouter_Path_Policies(t *testing.T) {
+	r := router.New()
+	r.GET("/users", func(c router.Context) error {
+		return c.Text(200, "users")
+	})
+	r.POST("/users", func(c router.Context) error {
+		return c.Text(201, "created")
+	})
+	r.GET("/posts/", func(c router.Context) error {
+		return c.Text(200, "posts")
+	})
+	r.GET("/a/c", func(c router.Context) error {
+		return c.Text(200, "a/c")
+	})
+
+	for _, path := range []string{"/users/", "/posts", "/a//c", "/a/b/../c", "/a/./c"} {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 404, rw.status, path)
+	}
+
+	r.SetTrailingSlashPolicy(router.PolicyRedirect)
+	r.SetDuplicateSlashPolicy(router.PolicyRedirect)
+	r.SetDotSegmentPolicy(router.PolicyRedirect)
+
+	redirects := map[string]string{
+		"/users/?page=2": "/users?page=2",
+		"/posts":         "/posts/",
+		"/a//c":          "/a/c",
+		"/a/b/../c":      "/a/c",
+		"/a/./c":         "/a/c",
+		"//a/b/..//c/":   "/a/c",
+	}
+
+	for path, location := range redirects {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 301, rw.status, path)
+		assert.Equal(t, location, rw.Header().Get("Location"), path)
+	}
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("POST", "/users/"))
+	assert.Equal(t, 308, rw.status)
+	assert.Equal(t, "/users", rw.Header().Get("Location"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/users"))
+	assert.Equal(t, 200, rw.status)
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/comments/"))
+	assert.Equal(t, 404, rw.status)
+
+	r.SetTrailingSlashPolicy(router.PolicyEqual)
+	r.SetDuplicateSlashPolicy(router.PolicyEqual)
+	r.SetDotSegmentPolicy(router.PolicyEqual)
+
+	paths := map[string]string{
+		"/users/":      "users",
+		"/posts":       "posts",
+		"/a//c":        "a/c",
+		"/a/b/../c":    "a/c",
+		"//a/b/..//c/": "a/c",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status, path)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("PUT", "/users/"))
+	assert.Equal(t, 405, rw.status)
+	assert.Equal(t, "GET, HEAD, OPTIONS, POST", rw.Header().Get("Allow"))
+}
+
+func TestRouter_Path_Policies_With_Parameters(t *testing.T) {
+	r := router.New()
+	r.GET("/users", func(c router.Context) error {
+		return c.Text(200, "users")
+	})
+	r.GET("/users/:id", func(c router.Context) error {
+		return c.Text(200, "user "+c.Parameter("id"))
+	})
+	r.GET("/files/:name.json", func(c router.Context) error {
+		return c.Text(200, "file "+c.Parameter("name"))
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/files/.json"))
+	assert.Equal(t, 404, rw.status)
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/users/"))
+	assert.Equal(t, 404, rw.status)
+
+	r.SetTrailingSlashPolicy(router.PolicyRedirect)
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/users/"))
+	assert.Equal(t, 301, rw.status)
+	assert.Equal(t, "/users", rw.Header().Get("Location"))
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/users/13"))
+	assert.Equal(t, "user 13", rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/files/a.json"))
+	assert.Equal(t, "file a", rw.stringBody())
+}
+
+func TestRouter_SetCaseInsensitive(t *testing.T) {
+	r := router.New()
+	r.GET("/users/:name/Posts", func(c router.Context) error {
+		return c.Text(200, c.Parameter("name"))
+	})
+	r.GET("/api/Docs", func(c router.Context) error {
+		return c.Text(200, "Docs")
+	})
+	r.GET("/api/docs", func(c router.Context) error {
+		return c.Text(200, "docs")
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/USERS/Milad/posts"))
+	assert.Equal(t, 404, rw.status)
+
+	r.SetCaseInsensitive(true)
+
+	paths := map[string]string{
+		"/USERS/Milad/posts": "Milad",
+		"/users/milad/POSTS": "milad",
+		"/api/Docs":          "Docs",
+		"/api/docs":          "docs",
+		"/API/DOCS":          "Docs",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, 200, rw.status, path)
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
+}
+
+func TestRouter_Internal_Error(t *testing.T) {
+	r := router.New()
+
+	r.SetNotFoundHandler(func(c router.Context) error {
+		return errors.New("error inside error handler")
+	})
+
+	r.GET("/error", func(c router.Context) error {
+		return errors.New("error")
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/error"))
+	assert.Equal(t, 500, rw.status)
+	assert.Equal(t, InternalErrorJson, rw.stringBody())
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/"))
+	assert.Equal(t, 500, rw.status)
+	assert.Equal(t, InternalErrorJson, rw.stringBody())
+
+}
+
+func TestRouter_With_HTTP_Errors(t *testing.T) {
+	r := router.New()
+	r.GET("/users/:id", func(c router.Context) error {
+		return router.NewHTTPError(404, "User not found.")
+	})
+	r.POST("/users", func(c router.Context) error {
+		return router.NewHTTPError(422, "").WithDetails(response.M{"name": "required"})
+	})
+	r.GET("/wrapped", func(c router.Context) error {
+		return fmt.Errorf("wrapped: %w", router.NewHTTPError(409, "Conflict.").WithInternal(errors.New("db")))
+	})
+	r.GET("/unavailable", func(c router.Context) error {
+		return router.NewHTTPError(503, "").WithInternal(errors.New("db is down"))
+	})
+
+	type test struct {
+		method, path string
+		status       int
+		body         string
+	}
+
+	tests := []test{
+		{"GET", "/users/13", 404, "{\"message\":\"User not found.\"}"},
+		{"POST", "/users", 422, "{\"details\":{\"name\":\"required\"},\"message\":\"Unprocessable Entity\"}"},
+		{"GET", "/wrapped", 409, "{\"message\":\"Conflict.\"}"},
+		{"GET", "/unavailable", 503, "{\"message\":\"Service Unavailable\"}"},
+	}
+
+	for _, tt := range tests {
+		rw := newResponse()
+		r.Serve(rw, newRequest(tt.method, tt.path))
+		assert.Equal(t, tt.status, rw.status, tt.path)
+		assert.Equal(t, tt.body, rw.stringBody(), tt.path)
+	}
+
+	cause := errors.New("db is down")
+	err := router.NewHTTPError(503, "").WithInternal(cause)
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "503 Service Unavailable: db is down", err.Error())
+}
+
+func TestRouter_SetErrorHandler(t *testing.T) {
 	r := router.New()
-	r.AddMiddlewares([]router.Middleware{Middleware1, Middleware2})
-	r.GET("/", func(c router.Context) error {
-		b := c.Response().Header().Get("Middleware1") + " " + c.Response().Header().Get("Middleware2")
-		return c.Text(200, b)
+	r.SetErrorHandler(func(c router.Context, err error) {
+		var e *router.HTTPError
+		if errors.As(err, &e) {
+			_ = c.Text(e.Status, "HTTP error: "+e.Message)
+			return
+		}
+		_ = c.Text(500, "error: "+err.Error())
+	})
+	r.SetNotFoundHandler(func(c router.Context) error {
+		return router.NewHTTPError(404, "Nothing here.")
 	})
 
-	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/"))
-	assert.Equal(t, 200, rw.status)
-	assert.Equal(t, "Middleware1 Middleware2", rw.stringBody())
+	r.GET("/error", func(c router.Context) error {
+		return errors.New("failed")
+	})
+	r.GET("/http-error", func(c router.Context) error {
+		return router.NewHTTPError(400, "Bad input.")
+	})
+
+	paths := map[string]string{
+		"/error":      "error: failed",
+		"/http-error": "HTTP error: Bad input.",
+		"/missing":    "HTTP error: Nothing here.",
+	}
+
+	for path, body := range paths {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", path))
+		assert.Equal(t, body, rw.stringBody(), path)
+	}
 }
 
-func TestRouter_SetNotFoundHandler(t *testing.T) {
+func TestRouter_SetProblemDetails(t *testing.T) {
 	r := router.New()
+	r.SetProblemDetails(true)
 
-	r.SetNotFoundHandler(func(c router.Context) error {
-		return c.Text(504, "New Not Found")
+	r.GET("/users/:id", func(c router.Context) error {
+		return router.NewHTTPError(404, "User not found.")
 	})
-
-	r.GET("/%", func(c router.Context) error {
-		return c.Text(200, "OK")
+	r.POST("/users", func(c router.Context) error {
+		return router.NewHTTPError(422, "").WithDetails(response.M{"name": "required"})
 	})
+	r.GET("/error", func(c router.Context) error {
+		return errors.New("failed")
+	})
+	r.GET("/problem", func(c router.Context) error {
+		p := problem.New(409)
+		p.Type = "https://example.com/problems/out-of-credit"
+		p.Detail = "Your balance is 30."
+		p.Instance = "/accounts/12345"
+		p.Extensions = map[string]interface{}{"balance": 30}
+		return p
+	})
+
+	type test struct {
+		method, path string
+		status       int
+		body         string
+	}
+
+	tests := []test{
+		{"GET", "/users/13", 404, "{\"detail\":\"User not found.\",\"status\":404,\"title\":\"Not Found\"}"},
+		{"POST", "/users", 422, "{\"details\":{\"name\":\"required\"},\"status\":422,\"title\":\"Unprocessable Entity\"}"},
+		{"GET", "/error", 500, "{\"status\":500,\"title\":\"Internal Server Error\"}"},
+		{"GET", "/problem", 409, "{\"balance\":30,\"detail\":\"Your balance is 30.\",\"instance\":\"/accounts/12345\",\"status\":409,\"title\":\"Conflict\",\"type\":\"https://example.com/problems/out-of-credit\"}"},
+		{"GET", "/missing", 404, "{\"status\":404,\"title\":\"Not Found\"}"},
+		{"DELETE", "/users", 405, "{\"status\":405,\"title\":\"Method Not Allowed\"}"},
+	}
+
+	for _, tt := range tests {
+		rw := newResponse()
+		r.Serve(rw, newRequest(tt.method, tt.path))
+		assert.Equal(t, tt.status, rw.status, tt.path)
+		assert.Equal(t, tt.body, rw.stringBody(), tt.path)
+		assert.Equal(t, problem.ContentType, rw.Header().Get("Content-Type"), tt.path)
+	}
 
+	var p problem.Problem
+	assert.NoError(t, json.Unmarshal([]byte(tests[3].body), &p))
+	assert.Equal(t, 409, p.Status)
+	assert.Equal(t, "Conflict: Your balance is 30.", p.Error())
+	assert.Equal(t, map[string]interface{}{"balance": float64(30)}, p.Extensions)
+
+	r.SetProblemDetails(false)
 	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/"))
-	assert.Equal(t, 504, rw.status)
-	assert.Equal(t, "New Not Found", rw.stringBody())
+	r.Serve(rw, newRequest("GET", "/problem"))
+	assert.Equal(t, 409, rw.status)
+	assert.Equal(t, problem.ContentType, rw.Header().Get("Content-Type"))
 
 	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/%"))
-	assert.Equal(t, 504, rw.status)
-	assert.Equal(t, "New Not Found", rw.stringBody())
+	r.Serve(rw, newRequest("GET", "/missing"))
+	assert.Equal(t, "{\"message\":\"Not found.\"}", rw.stringBody())
 }
 
-func TestRouter_Internal_Error(t *testing.T) {
+func TestRouter_With_Panics(t *testing.T) {
 	r := router.New()
-
-	r.SetNotFoundHandler(func(c router.Context) error {
-		return errors.New("error inside error handler")
+	r.GET("/panic", func(c router.Context) error {
+		panic("boom")
+	})
+	r.GET("/panic-error", func(c router.Context) error {
+		panic(router.NewHTTPError(409, "Conflict."))
+	})
+	r.GET("/panic-after-write", func(c router.Context) error {
+		_ = c.Text(200, "partial")
+		panic("boom")
+	})
+	r.GET("/abort", func(c router.Context) error {
+		panic(http.ErrAbortHandler)
 	})
 
-	r.GET("/error", func(c router.Context) error {
-		return errors.New("error")
+	var reported []*router.PanicError
+	r.SetPanicReporter(func(c router.Context, err *router.PanicError) {
+		reported = append(reported, err)
 	})
 
-	rw := newResponse()
-	r.Serve(rw, newRequest("GET", "/error"))
-	assert.Equal(t, 500, rw.status)
-	assert.Equal(t, InternalErrorJson, rw.stringBody())
+	type test struct {
+		path   string
+		status int
+		body   string
+	}
 
-	rw = newResponse()
-	r.Serve(rw, newRequest("GET", "/"))
-	assert.Equal(t, 500, rw.status)
-	assert.Equal(t, InternalErrorJson, rw.stringBody())
+	tests := []test{
+		{"/panic", 500, InternalErrorJson},
+		{"/panic-error", 409, "{\"message\":\"Conflict.\"}"},
+		{"/panic-after-write", 200, "partial"},
+	}
 
+	for _, tt := range tests {
+		rw := newResponse()
+		r.Serve(rw, newRequest("GET", tt.path))
+		assert.Equal(t, tt.status, rw.status, tt.path)
+		assert.Equal(t, tt.body, rw.stringBody(), tt.path)
+	}
+
+	assert.Len(t, reported, 3)
+	assert.Equal(t, "panic: boom", reported[0].Error())
+	assert.Equal(t, "boom", reported[0].Value)
+	assert.Contains(t, string(reported[0].Stack), "router_test.go")
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		r.Serve(newResponse(), newRequest("GET", "/abort"))
+	})
+	assert.Len(t, reported, 3)
 }
 
 func TestRouter_With_Different_Responses(t *testing.T) {
@@ -639,6 +1971,253 @@ func TestRouter_With_Different_Responses(t *testing.T) {
 	assert.Equal(t, InternalErrorJson, rw.stringBody())
 }
 
+func TestRouter_With_ResponseWriter(t *testing.T) {
+	var logs []string
+	logger := func(next router.Handler) router.Handler {
+		return func(c router.Context) error {
+			c.Writer().Before(func() {
+				c.Writer().Header().Set("X-Before", "yes")
+			})
+			c.Writer().After(func() {
+				logs = append(logs, "after "+strconv.FormatInt(c.Writer().Size(), 10))
+			})
+			err := next(c)
+			logs = append(logs, fmt.Sprintf("%d %d %v", c.Writer().Status(), c.Writer().Size(), c.Writer().Written()))
+			return err
+		}
+	}
+
+	r := router.New()
+	r.WithMiddleware(logger, func() {
+		r.GET("/twice", func(c router.Context) error {
+			_ = c.JSON(201, response.M{"n": 1})
+			return c.JSON(500, response.M{"n": 2})
+		})
+		r.GET("/nothing", func(c router.Context) error {
+			return nil
+		})
+		r.GET("/hijack", func(c router.Context) error {
+			_, _, err := c.Writer().Hijack()
+			return err
+		})
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/twice"))
+	assert.Equal(t, 201, rw.status)
+	assert.Equal(t, "{\"n\":1}{\"n\":2}", rw.stringBody())
+	assert.Equal(t, "yes", rw.Header().Get("X-Before"))
+	assert.Equal(t, []string{"after 7", "after 14", "201 14 true"}, logs)
+
+	logs = nil
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/nothing"))
+	assert.Equal(t, []string{"200 0 false"}, logs)
+	assert.Equal(t, "", rw.Header().Get("X-Before"))
+
+	logs = nil
+	rw = newResponse()
+	r.Serve(rw, newRequest("HEAD", "/twice"))
+	assert.Equal(t, "", rw.stringBody())
+	assert.Equal(t, []string{"after 7", "after 14", "201 14 true"}, logs)
+
+	rw = newResponse()
+	r.Serve(rw, newRequest("GET", "/hijack"))
+	assert.Equal(t, 500, rw.status)
+
+	recorder := httptest.NewRecorder()
+	r.GET("/stream", func(c router.Context) error {
+		w := c.Response()
+		_, _ = w.Write([]byte("chunk"))
+		w.(http.Flusher).Flush()
+		assert.Equal(t, http.ErrNotSupported, w.(http.Pusher).Push("/style.css", nil))
+		return nil
+	})
+	r.Serve(recorder, httptest.NewRequest("GET", "/stream", nil))
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, "chunk", recorder.Body.String())
+}
+
+// wrappedContext is a Context that middlewares pass to the next handlers instead of the original one.
+type wrappedContext struct {
+	router.Context
+}
+
+func TestRouter_Use(t *testing.T) {
+	var logs []string
+	logger := func(next router.Handler) router.Handler {
+		return func(c router.Context) error {
+			err := next(c)
+			logs = append(logs, fmt.Sprintf("%s %s %d", c.Request().Method, c.Request().URL.Path, c.Writer().Status()))
+			return err
+		}
+	}
+	rewriter := func(next router.Handler) router.Handler {
+		return func(c router.Context) error {
+			if strings.HasPrefix(c.Request().URL.Path, "/legacy/") {
+				request := c.Request().Clone(c.Request().Context())
+				request.URL.Path = strings.TrimPrefix(request.URL.Path, "/legacy")
+				c.SetRequest(request)
+			}
+			return next(c)
+		}
+	}
+	guard := func(next router.Handler) router.Handler {
+		return func(c router.Context) error {
+			if c.Request().Header.Get("Authorization") == "" {
+				return router.NewHTTPError(401, "")
+			}
+			return next(c)
+		}
+	}
+
+	wrapper := func(next router.Handler) router.Handler {
+		return func(c router.Context) error {
+			return next(wrappedContext{c})
+		}
+	}
+
+	r := router.New()
+	r.Use(logger, rewriter)
+	r.Use(guard, wrapper)
+
+	r.GET("/users", func(c router.Context) error {
+		return c.Text(200, "users")
+	})
+	r.GET("/error", func(c router.Context) error {
+		return errors.New("failed")
+	})
+
+	type test struct {
+		method, path string
+		status       int
+		body         string
+	}
+
+	tests := []test{
+		{"GET", "/users", 200, "users"},
+		{"GET", "/legacy/users", 200, "users"},
+		{"GET", "/missing", 404, "{\"message\":\"Not found.\"}"},
+		{"POST", "/users", 405, "{\"message\":\"Method not allowed.\"}"},
+		{"GET", "/error", 500, InternalErrorJson},
+	}
+
+	for _, tt := range tests {
+		rw := newResponse()
+		request := newRequest(tt.method, tt.path)
+		request.Header = http.Header{"Authorization": {"Bearer token"}}
+		r.Serve(rw, request)
+		assert.Equal(t, tt.status, rw.status, tt.path)
+		assert.Equal(t, tt.body, rw.stringBody(), tt.path)
+	}
+
+	assert.Equal(t, []string{"GET /users 200", "GET /users 200", "GET /missing 404", "POST /users 405", "GET /error 500"}, logs)
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/missing"))
+	assert.Equal(t, 401, rw.status)
+	assert.Equal(t, "{\"message\":\"Unauthorized\"}", rw.stringBody())
+}
+
+func TestRouter_Use_With_Parameters(t *testing.T) {
+	var before, after map[string]string
+	r := router.New()
+	r.Use(func(next router.Handler) router.Handler {
+		return func(c router.Context) error {
+			before = c.Parameters()
+			err := next(c)
+			after = c.Parameters()
+			return err
+		}
+	})
+	r.GET("/users/:id", func(c router.Context) error {
+		return c.JSON(200, c.Parameters())
+	})
+
+	rw := newResponse()
+	r.Serve(rw, newRequest("GET", "/users/42"))
+	assert.Equal(t, "{\"id\":\"42\"}", rw.stringBody())
+	assert.Equal(t, map[string]string{}, before)
+	assert.Equal(t, map[string]string{"id": "42"}, after)
+}
+
+func TestRouter_Use_With_Concurrent_Requests(t *testing.T) {
+	var mutex sync.Mutex
+	wrapped := map[string]int{}
+	counter := func(name string) router.Middleware {
+		return func(next router.Handler) router.Handler {
+			mutex.Lock()
+			wrapped[name]++
+			mutex.Unlock()
+			return next
+		}
+	}
+
+	r := router.New()
+	r.Use(counter("first"))
+	r.Use(counter("second"), counter("third"))
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, "OK")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+			assert.Equal(t, "OK", rw.Body.String())
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"first": 1, "second": 1, "third": 1}, wrapped)
+}
+
+func TestRouter_As_HTTP_Handler(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, "OK")
+	})
+
+	var handler http.Handler = r
+	server := httptest.NewServer(http.TimeoutHandler(r.Handler(), time.Second, "timeout"))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, "OK", string(body))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "OK", rw.Body.String())
+
+	r.GET("/users/:id", func(c router.Context) error {
+		return c.Text(200, "user "+c.Parameter("id"))
+	})
+
+	rw = httptest.NewRecorder()
+	http.StripPrefix("/api", r).ServeHTTP(rw, httptest.NewRequest("GET", "/api/users/13?page=1", nil))
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "user 13", rw.Body.String())
+
+	client, err := http.NewRequest("GET", "http://example.com/users/7", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", client.RequestURI)
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, client)
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "user 7", rw.Body.String())
+}
+
 func TestRouter_Start(t *testing.T) {
 	r := router.New()
 	r.GET("/", func(c router.Context) error {
@@ -657,3 +2236,213 @@ func TestRouter_Start(t *testing.T) {
 		assert.True(t, true)
 	}
 }
+
+func TestRouter_Listen_And_Shutdown(t *testing.T) {
+	started := make(chan bool)
+	r := router.New()
+	r.GET("/slow", func(c router.Context) error {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		return c.Text(200, "slow")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	ec := make(chan error)
+	go func() {
+		ec <- r.Listen(listener)
+	}()
+
+	bc := make(chan string)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		bc <- string(body)
+	}()
+
+	<-started
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.Equal(t, "slow", <-bc)
+	assert.NoError(t, <-ec)
+}
+
+func TestRouter_Listen_With_Unix_Socket(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, "OK")
+	})
+
+	socket := filepath.Join(t.TempDir(), "router.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets are not supported:", err)
+	}
+
+	ec := make(chan error)
+	go func() {
+		ec <- r.Listen(listener)
+	}()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	response, err := client.Get("http://unix/")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	assert.Equal(t, "OK", string(body))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, r.Shutdown(ctx))
+	assert.NoError(t, <-ec)
+}
+
+func TestRouter_StartTLS(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, "secure")
+	})
+
+	certFile, keyFile, client := tlsFiles(t)
+	address := freeAddress(t)
+
+	ec := make(chan error)
+	go func() {
+		ec <- r.StartTLS(address, certFile, keyFile)
+	}()
+
+	assert.Equal(t, "secure", waitForBody(t, client, "https://"+address+"/"))
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.NoError(t, <-ec)
+
+	assert.Error(t, router.New().StartTLS(freeAddress(t), "missing.pem", "missing.pem"))
+}
+
+func TestRouter_StartServer(t *testing.T) {
+	r := router.New()
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, "router")
+	})
+
+	certFile, keyFile, client := tlsFiles(t)
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+
+	secure := &http.Server{Addr: freeAddress(t), TLSConfig: &tls.Config{Certificates: []tls.Certificate{certificate}}}
+	custom := &http.Server{Addr: freeAddress(t), Handler: http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("custom"))
+	})}
+
+	ec := make(chan error, 2)
+	for _, server := range []*http.Server{secure, custom} {
+		go func(server *http.Server) {
+			ec <- r.StartServer(server)
+		}(server)
+	}
+
+	assert.Equal(t, "router", waitForBody(t, client, "https://"+secure.Addr+"/"))
+	assert.Equal(t, "custom", waitForBody(t, http.DefaultClient, "http://"+custom.Addr+"/"))
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.NoError(t, <-ec)
+	assert.NoError(t, <-ec)
+}
+
+func TestRouter_SetShutdownSignals(t *testing.T) {
+	process, err := os.FindProcess(os.Getpid())
+	assert.NoError(t, err)
+
+	started := make(chan bool)
+	r := router.New()
+	r.SetShutdownSignals(time.Second, os.Interrupt)
+	r.GET("/slow", func(c router.Context) error {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		return c.Text(200, "slow")
+	})
+	r.GET("/", func(c router.Context) error {
+		return c.Text(200, "OK")
+	})
+
+	address := freeAddress(t)
+	ec := make(chan error)
+	go func() {
+		ec <- r.Start(address)
+	}()
+
+	assert.Equal(t, "OK", waitForBody(t, http.DefaultClient, "http://"+address+"/"))
+
+	bc := make(chan string)
+	go func() {
+		bc <- waitForBody(t, http.DefaultClient, "http://"+address+"/slow")
+	}()
+
+	<-started
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Skip("signals are not supported:", err)
+	}
+
+	assert.NoError(t, <-ec)
+	assert.Equal(t, "slow", <-bc)
+}
+
+func TestRouter_Shutdown_Before_Start(t *testing.T) {
+	r := router.New()
+	assert.NoError(t, r.Shutdown(context.Background()))
+
+	ec := make(chan error)
+	go func() {
+		ec <- r.Start(freeAddress(t))
+	}()
+
+	select {
+	case err := <-ec:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "the router has started after the shutdown")
+	}
+}
+
+// Benchmarks
+
+func BenchmarkRouter_With_Static_Routes(b *testing.B) {
+	r := router.New()
+	for _, path := range []string{"/", "/users", "/users/me", "/users/me/posts", "/posts", "/posts/latest"} {
+		r.GET(path, func(c router.Context) error {
+			return nil
+		})
+	}
+
+	rw := newResponse()
+	request := newRequest("GET", "/users/me/posts")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Serve(rw, request)
+	}
+}
+
+func BenchmarkRouter_With_Route_Parameters(b *testing.B) {
+	r := router.New()
+	r.Define("id", "[0-9]+")
+	r.GET("/users/:id/posts/:post", func(c router.Context) error {
+		return nil
+	})
+
+	rw := newResponse()
+	request := newRequest("GET", "/users/13/posts/33")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Serve(rw, request)
+	}
+}
@@ -4,11 +4,30 @@ package router
 type state struct {
 	prefix      string
 	middlewares []Middleware
+	host        *host
+	version     *version
+}
+
+// extend creates a new state with the given prefix and middlewares appended to the attributes of this state.
+func (s *state) extend(prefix string, middlewares []Middleware) *state {
+	merged := make([]Middleware, 0, len(s.middlewares)+len(middlewares))
+	merged = append(append(merged, s.middlewares...), middlewares...)
+	return newState(s.prefix+prefix, merged, s.host, s.version)
+}
+
+// withHost creates a new state with the attributes of this state, but the given host.
+func (s *state) withHost(h *host) *state {
+	return newState(s.prefix, s.middlewares, h, s.version)
+}
+
+// withVersion creates a new state with the attributes of this state, but the given API version.
+func (s *state) withVersion(v *version) *state {
+	return newState(s.prefix, s.middlewares, s.host, v)
 }
 
 // newState creates a new state instance.
-func newState(prefix string, middlewares []Middleware) *state {
-	return &state{prefix, middlewares}
+func newState(prefix string, middlewares []Middleware, host *host, version *version) *state {
+	return &state{prefix, middlewares, host, version}
 }
 
 // stateStack holds the stack of states (group attributes).
@@ -16,25 +35,17 @@ type stateStack struct {
 	states []*state
 }
 
-// prefix returns current state (group) prefix.
-func (g *stateStack) prefix() string {
-	if len(g.states) > 0 {
-		return g.states[len(g.states)-1].prefix
-	}
-	return ""
-}
-
-// middlewares returns current state (group) middlewares.
-func (g *stateStack) middlewares() []Middleware {
+// current returns current state (group), or an empty state if there is no group.
+func (g *stateStack) current() *state {
 	if len(g.states) > 0 {
-		return g.states[len(g.states)-1].middlewares
+		return g.states[len(g.states)-1]
 	}
-	return []Middleware{}
+	return newState("", []Middleware{}, nil, nil)
 }
 
 // push adds a new state (group) to the stack.
-func (g *stateStack) push(prefix string, middleware []Middleware) {
-	g.states = append(g.states, newState(g.prefix()+prefix, append(g.middlewares(), middleware...)))
+func (g *stateStack) push(s *state) {
+	g.states = append(g.states, s)
 }
 
 // pop removes (closes) the last state (group).
@@ -0,0 +1,142 @@
+package router
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Versioning determines how the router resolves the API version of requests.
+// The router tries the path prefix, the Accept header parameter, and the custom header in order.
+// The routes of the latest version that is not after the resolved version handle the requests,
+// and the latest version handles the requests without any resolved version.
+type Versioning struct {
+	// Prefix enables resolving the version from the first path part, like `/v2/users`.
+	// The router removes the prefix before matching the routes, and adds it to the URLs of versioned routes.
+	Prefix bool
+	// Parameter is the name of the media type parameter in the Accept header, like `version` in
+	// `application/json; version=2`.
+	Parameter string
+	// Header is the name of the custom request header, like `X-Api-Version`.
+	Header string
+}
+
+// version holds an API version, like `2` or `2.1`, and its deprecation details.
+type version struct {
+	name        string
+	numbers     []int
+	deprecated  bool
+	deprecation time.Time
+	sunset      time.Time
+}
+
+// headers sets the Deprecation and Sunset headers if the version is deprecated.
+// The Deprecation header holds the deprecation date if it's known, and `true` otherwise.
+func (v *version) headers(header http.Header) {
+	if !v.deprecated {
+		return
+	}
+
+	if v.deprecation.IsZero() {
+		header.Set("Deprecation", "true")
+	} else {
+		header.Set("Deprecation", "@"+strconv.FormatInt(v.deprecation.Unix(), 10))
+	}
+
+	if !v.sunset.IsZero() {
+		header.Set("Sunset", v.sunset.UTC().Format(http.TimeFormat))
+	}
+}
+
+// compareVersions compares the versions and returns -1, 0, or +1.
+// Missing numbers count as zero, and the nil version (unversioned) comes before all others.
+func compareVersions(a, b *version) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	for i := 0; i < len(a.numbers) || i < len(b.numbers); i++ {
+		x, y := 0, 0
+		if i < len(a.numbers) {
+			x = a.numbers[i]
+		}
+		if i < len(b.numbers) {
+			y = b.numbers[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// parseVersion parses a version like `2`, `v2`, or `2.1`.
+// It returns an error if the version has anything but dot-separated numbers.
+func parseVersion(name string) (*version, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(name, "v"), "V")
+
+	parts := strings.Split(trimmed, ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || part[0] == '+' {
+			return nil, fmt.Errorf("router: invalid version %q", name)
+		}
+		numbers[i] = number
+	}
+
+	return &version{name: trimmed, numbers: numbers}, nil
+}
+
+// splitVersion removes the version prefix, like `/v2`, from the path and returns it with the rest of the path.
+// It returns an empty prefix if the first path part is not a version.
+func splitVersion(path string) (string, string) {
+	if len(path) < 3 || path[0] != '/' || (path[1] != 'v' && path[1] != 'V') || path[2] < '0' || path[2] > '9' {
+		return "", path
+	}
+
+	end := strings.IndexByte(path[1:], '/') + 1
+	if end == 0 {
+		end = len(path)
+	}
+
+	if _, err := parseVersion(path[1:end]); err != nil {
+		return "", path
+	}
+	if end == len(path) {
+		return path, "/"
+	}
+	return path[:end], path[end:]
+}
+
+// resolveVersion resolves the version of the request from the Accept header parameter or the custom header,
+// whichever is configured and present. It returns an empty string if the request has no version.
+func (v Versioning) resolveVersion(request *http.Request) string {
+	if v.Parameter != "" {
+		for _, mediaRange := range strings.Split(request.Header.Get("Accept"), ",") {
+			if _, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange)); err == nil {
+				if value := params[strings.ToLower(v.Parameter)]; value != "" {
+					return value
+				}
+			}
+		}
+	}
+
+	if v.Header != "" {
+		return request.Header.Get(v.Header)
+	}
+
+	return ""
+}
@@ -0,0 +1,144 @@
+package router
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter is the http.ResponseWriter of contexts.
+// It wraps the original http.ResponseWriter to track the status code, the size of the body, and whether the
+// response is committed (written), and to run the hooks before and after writing the response.
+// It ignores the status codes of the responses that are committed already, so the header is sent only once.
+// It supports http.Flusher, http.Hijacker, and http.Pusher as far as the original http.ResponseWriter does.
+type ResponseWriter struct {
+	writer  http.ResponseWriter
+	status  int
+	size    int64
+	written bool
+	discard bool
+	before  []func()
+	after   []func()
+	context *DefaultContext
+}
+
+// reset prepares the ResponseWriter for a new response.
+func (w *ResponseWriter) reset(rw http.ResponseWriter) {
+	w.writer = rw
+	w.status = http.StatusOK
+	w.size = 0
+	w.written = false
+	w.discard = false
+	w.before = w.before[:0]
+	w.after = w.after[:0]
+}
+
+// Before adds a hook to run right before sending the header (committing the response).
+// The hooks could modify the header yet.
+func (w *ResponseWriter) Before(hook func()) {
+	w.before = append(w.before, hook)
+}
+
+// After adds a hook to run right after writing each part of the body.
+func (w *ResponseWriter) After(hook func()) {
+	w.after = append(w.after, hook)
+}
+
+// Header returns the header map that will be sent by WriteHeader.
+func (w *ResponseWriter) Header() http.Header {
+	return w.writer.Header()
+}
+
+// WriteHeader sends the header with the given status code and commits the response.
+// It ignores the call if the response is committed already.
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+
+	for _, hook := range w.before {
+		hook()
+	}
+
+	w.status = status
+	w.written = true
+	w.writer.WriteHeader(status)
+}
+
+// Write writes the body and commits the response (with 200 status code, if the header is not sent yet).
+// It discards the body of the HEAD requests that the GET routes serve, but counts it in the size.
+func (w *ResponseWriter) Write(body []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	n := len(body)
+	var err error
+	if !w.discard {
+		n, err = w.writer.Write(body)
+	}
+	w.size += int64(n)
+
+	for _, hook := range w.after {
+		hook()
+	}
+
+	return n, err
+}
+
+// Status returns the status code of the response.
+// It's 200 until the response is committed, as it's the status code that net/http sends by default.
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of bytes of the body that are written so far.
+func (w *ResponseWriter) Size() int64 {
+	return w.size
+}
+
+// Written checks if the response is committed (the header is sent).
+func (w *ResponseWriter) Written() bool {
+	return w.written
+}
+
+// Flush sends the buffered data to the client, and commits the response (with 200 status code, if the header is
+// not sent yet). It does nothing if the original http.ResponseWriter doesn't support flushing.
+func (w *ResponseWriter) Flush() {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, and marks the response as committed.
+// It returns an error if the original http.ResponseWriter doesn't support hijacking.
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("router: the response writer doesn't support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.written = true
+	}
+	return conn, rw, err
+}
+
+// Push initiates an HTTP/2 server push.
+// It returns http.ErrNotSupported if the original http.ResponseWriter doesn't support pushing.
+func (w *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.writer.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the original http.ResponseWriter, so http.ResponseController could use its features.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.writer
+}